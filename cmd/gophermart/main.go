@@ -7,9 +7,11 @@ import (
 	"net/http"
 
 	"github.com/mdflamingo/Gofermart/internal/config"
+	"github.com/mdflamingo/Gofermart/internal/events"
 	"github.com/mdflamingo/Gofermart/internal/handler"
 	"github.com/mdflamingo/Gofermart/internal/logger"
 	"github.com/mdflamingo/Gofermart/internal/repository"
+	"github.com/mdflamingo/Gofermart/internal/service"
 	"go.uber.org/zap"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -27,8 +29,8 @@ func run(conf *config.Config) error {
 		return err
 	}
 
-	if conf.CookieSecretKey == "" {
-		logger.Log.Fatal("CookieSecretKey is required")
+	if conf.CookieSecretKey == "" && conf.JWTKeysFile == "" {
+		logger.Log.Fatal("CookieSecretKey or JWT keyring is required")
 	}
 
 	logger.Log.Info("Running server", zap.String("address", conf.RunAddr))
@@ -39,10 +41,22 @@ func run(conf *config.Config) error {
 	}
 	defer storage.Close()
 
+	if conf.BootstrapAdminLogin != "" {
+		if err := service.NewAdminService(storage).BootstrapAdmin(conf.BootstrapAdminLogin); err != nil {
+			logger.Log.Fatal("Failed to bootstrap admin", zap.Error(err))
+		}
+	}
+
 	worker := handler.NewAccrualWorker(conf.AccrualHost, storage)
 	go worker.Start(context.Background())
 
-	r := handler.NewRouter(conf, storage, worker)
+	hub := events.NewHub(storage)
+	go hub.Run(context.Background())
+
+	r, err := handler.NewRouter(conf, storage, worker, hub)
+	if err != nil {
+		return err
+	}
 
 	return http.ListenAndServe(conf.RunAddr, r)
 }
@@ -0,0 +1,221 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type contextKey struct{}
+
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+)
+
+// Resolver determines the client address of a request, trusting the
+// forwarding header only when it was added by a configured proxy.
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// NewResolver parses a comma-separated list of trusted proxy CIDRs; bare
+// addresses are treated as single-host prefixes. An empty list trusts no
+// proxy and forwarding headers are ignored. header names the one header the
+// proxies append to, X-Forwarded-For or Forwarded; the other is ignored, as
+// a proxy passes through whatever the client sent in it.
+func NewResolver(trustedProxies, header string) (*Resolver, error) {
+	r := &Resolver{}
+
+	switch http.CanonicalHeaderKey(header) {
+	case HeaderXForwardedFor:
+		r.header = HeaderXForwardedFor
+	case HeaderForwarded:
+		r.header = HeaderForwarded
+	default:
+		return nil, fmt.Errorf("unsupported forwarding header %q", header)
+	}
+
+	for _, entry := range strings.Split(trustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+
+	return r, nil
+}
+
+// Middleware stores the resolved client address in the request context.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextKey{}, res.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Resolve walks the forwarding chain from the nearest hop outwards and
+// returns the first address that is not a trusted proxy. A malformed or
+// obfuscated hop ends the walk at the last address that could be verified.
+func (res *Resolver) Resolve(r *http.Request) string {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return remoteHost(r)
+	}
+	if !res.isTrusted(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	if res.header == HeaderForwarded {
+		hops = forwardedFor(r.Header.Values(HeaderForwarded))
+	} else {
+		hops = xForwardedFor(r.Header.Values(HeaderXForwardedFor))
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !res.isTrusted(addr) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns the client address resolved by Middleware, or the
+// peer address when the middleware did not run.
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(contextKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseAddr accepts an address with or without a port, IPv6 optionally in
+// brackets, and strips any IPv4-mapped prefix and zone.
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return normalize(addrPort.Addr()), true
+	}
+
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return normalize(addr), true
+}
+
+func normalize(addr netip.Addr) netip.Addr {
+	return addr.Unmap().WithZone("")
+}
+
+func xForwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedFor extracts the for= parameters of an RFC 7239 Forwarded
+// header. Elements without a for= parameter yield an empty hop, which stops
+// the walk.
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, element := range splitQuoted(header, ',') {
+			var hop string
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hop = unquote(strings.TrimSpace(value))
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s on sep, ignoring separators inside quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuotes, escaped, start := false, false, 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && inQuotes:
+			escaped = true
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	var b strings.Builder
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
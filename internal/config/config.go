@@ -3,7 +3,9 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -13,6 +15,46 @@ type Config struct {
 	CookieSecretKey string
 	AccrualHost     string
 	AccrualPort     string
+	OrderValidators string
+	ReceiptTimeZone string
+	Argon2Memory    int
+	Argon2Time      int
+	Argon2Threads   int
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	JWTKeysFile     string
+	ResetNotifier   string
+	ResetTokenTTL   time.Duration
+
+	LoginFreeAttempts  int
+	LoginMaxAttempts   int
+	LoginIPMaxAttempts int
+	LoginLockout       time.Duration
+	LoginFailureWindow time.Duration
+	LoginGuardFailure  string
+
+	TOTPIssuer            string
+	WithdrawTOTPThreshold float64
+	WithdrawTOTPWindow    time.Duration
+
+	BootstrapAdminLogin string
+
+	LoginMinLength       int
+	LoginMaxLength       int
+	LoginPattern         string
+	PasswordMinLength    int
+	PasswordMinClasses   int
+	PasswordDenyListFile string
+
+	OIDCProvidersFile string
+	OIDCStateTTL      time.Duration
+
+	RateLimits           string
+	RateLimitStore       string
+	RateLimitFailureMode string
+
+	TrustedProxies  string
+	ForwardedHeader string
 }
 
 func ParseFlags() *Config {
@@ -24,6 +66,40 @@ func ParseFlags() *Config {
 	cookieSecretKey := flag.String("s", "default-secret-key", "you secret key for cookie")
 	accrualHost := flag.String("accrual-host", "localhost", "accrual system host")
 	accrualPort := flag.String("accrual-port", "8081", "accrual system port")
+	orderValidators := flag.String("order-validators", "*=luhn?min=2&max=64", "order number validators by prefix")
+	receiptTimeZone := flag.String("receipt-time-zone", "UTC", "IANA time zone of the time printed on fiscal receipts")
+	argon2Memory := flag.Int("argon2-memory", 19456, "argon2id memory cost in KiB")
+	argon2Time := flag.Int("argon2-time", 2, "argon2id number of iterations")
+	argon2Threads := flag.Int("argon2-threads", 1, "argon2id degree of parallelism")
+	accessTokenTTL := flag.Duration("access-token-ttl", 15*time.Minute, "access token lifetime")
+	refreshTokenTTL := flag.Duration("refresh-token-ttl", 30*24*time.Hour, "refresh token lifetime")
+	jwtKeysFile := flag.String("jwt-keys", "", "path to JWT keyring file, cookie secret key is used when empty")
+
+	resetNotifier := flag.String("reset-notifier", "log", "password reset delivery: log or file:<path>")
+	resetTokenTTL := flag.Duration("reset-token-ttl", 30*time.Minute, "password reset token lifetime")
+	loginFreeAttempts := flag.Int("login-free-attempts", 3, "failed logins allowed before delays are imposed")
+	loginMaxAttempts := flag.Int("login-max-attempts", 10, "failed logins per login before lockout")
+	loginIPMaxAttempts := flag.Int("login-ip-max-attempts", 100, "failed logins per client IP before lockout")
+	loginLockout := flag.Duration("login-lockout", 15*time.Minute, "login lockout duration")
+	loginFailureWindow := flag.Duration("login-failure-window", time.Hour, "period after which failed logins are forgotten")
+	loginGuardFailure := flag.String("login-guard-failure-mode", "open", "whether logins pass (open) or are rejected (closed) when failed attempts cannot be counted")
+	totpIssuer := flag.String("totp-issuer", "Gophermart", "issuer shown in authenticator apps")
+	withdrawTOTPThreshold := flag.Float64("withdraw-totp-threshold", 0, "withdrawals adding up to more than this sum within withdraw-totp-window require a two-factor code, disabled when zero")
+	withdrawTOTPWindow := flag.Duration("withdraw-totp-window", 24*time.Hour, "period over which withdrawals are added up for withdraw-totp-threshold")
+	bootstrapAdminLogin := flag.String("bootstrap-admin", "", "login granted the admin role at startup")
+	loginMinLength := flag.Int("login-min-length", 3, "minimum login length")
+	loginMaxLength := flag.Int("login-max-length", 255, "maximum login length")
+	loginPattern := flag.String("login-pattern", "", "regular expression logins must match, any printable characters when empty")
+	passwordMinLength := flag.Int("password-min-length", 8, "minimum password length")
+	passwordMinClasses := flag.Int("password-min-classes", 1, "minimum number of character classes (lower, upper, digits, symbols) in a password")
+	passwordDenyListFile := flag.String("password-denylist", "", "path to a file of denied passwords, one per line, in addition to the built-in list")
+	oidcProvidersFile := flag.String("oidc-providers", "", "path to a JSON file describing OpenID Connect providers, SSO is disabled when empty")
+	oidcStateTTL := flag.Duration("oidc-state-ttl", 10*time.Minute, "time allowed to complete an OpenID Connect sign-in")
+	rateLimits := flag.String("rate-limits", "auth=sliding_window?limit=10&window=1m;api=sliding_window?limit=300&window=1m&key=api_key;orders.lookup=sliding_window?limit=10&window=1m&key=user", "rate limit policies by name")
+	rateLimitStore := flag.String("rate-limit-store", "memory", "where rate limit state is kept: memory or postgres to share it between replicas")
+	rateLimitFailureMode := flag.String("rate-limit-failure-mode", "open", "whether requests pass (open) or are rejected (closed) when the rate limit store fails")
+	trustedProxies := flag.String("trusted-proxies", "", "comma-separated proxy CIDRs whose forwarding header is trusted")
+	forwardedHeader := flag.String("forwarded-header", "X-Forwarded-For", "header trusted proxies append the client address to: X-Forwarded-For or Forwarded")
 
 	flag.Parse()
 
@@ -33,6 +109,39 @@ func ParseFlags() *Config {
 	cfg.CookieSecretKey = getEnvOrDefault("COOKIE_SECRET_KEY", *cookieSecretKey)
 	cfg.AccrualHost = getEnvOrDefault("ACCRUAL_SYSTEM_ADDRESS", *accrualHost)
 	cfg.AccrualPort = getEnvOrDefault("ACCRUAL_SYSTEM_PORT", *accrualPort)
+	cfg.OrderValidators = getEnvOrDefault("ORDER_NUMBER_VALIDATORS", *orderValidators)
+	cfg.ReceiptTimeZone = getEnvOrDefault("RECEIPT_TIME_ZONE", *receiptTimeZone)
+	cfg.Argon2Memory = getEnvIntOrDefault("ARGON2_MEMORY", *argon2Memory)
+	cfg.Argon2Time = getEnvIntOrDefault("ARGON2_TIME", *argon2Time)
+	cfg.Argon2Threads = getEnvIntOrDefault("ARGON2_THREADS", *argon2Threads)
+	cfg.AccessTokenTTL = getEnvDurationOrDefault("ACCESS_TOKEN_TTL", *accessTokenTTL)
+	cfg.RefreshTokenTTL = getEnvDurationOrDefault("REFRESH_TOKEN_TTL", *refreshTokenTTL)
+	cfg.JWTKeysFile = getEnvOrDefault("JWT_KEYS_FILE", *jwtKeysFile)
+	cfg.ResetNotifier = getEnvOrDefault("PASSWORD_RESET_NOTIFIER", *resetNotifier)
+	cfg.ResetTokenTTL = getEnvDurationOrDefault("PASSWORD_RESET_TOKEN_TTL", *resetTokenTTL)
+	cfg.LoginFreeAttempts = getEnvIntOrDefault("LOGIN_FREE_ATTEMPTS", *loginFreeAttempts)
+	cfg.LoginMaxAttempts = getEnvIntOrDefault("LOGIN_MAX_ATTEMPTS", *loginMaxAttempts)
+	cfg.LoginIPMaxAttempts = getEnvIntOrDefault("LOGIN_IP_MAX_ATTEMPTS", *loginIPMaxAttempts)
+	cfg.LoginLockout = getEnvDurationOrDefault("LOGIN_LOCKOUT", *loginLockout)
+	cfg.LoginFailureWindow = getEnvDurationOrDefault("LOGIN_FAILURE_WINDOW", *loginFailureWindow)
+	cfg.LoginGuardFailure = getEnvOrDefault("LOGIN_GUARD_FAILURE_MODE", *loginGuardFailure)
+	cfg.TOTPIssuer = getEnvOrDefault("TOTP_ISSUER", *totpIssuer)
+	cfg.WithdrawTOTPThreshold = getEnvFloatOrDefault("WITHDRAW_TOTP_THRESHOLD", *withdrawTOTPThreshold)
+	cfg.WithdrawTOTPWindow = getEnvDurationOrDefault("WITHDRAW_TOTP_WINDOW", *withdrawTOTPWindow)
+	cfg.BootstrapAdminLogin = getEnvOrDefault("BOOTSTRAP_ADMIN_LOGIN", *bootstrapAdminLogin)
+	cfg.LoginMinLength = getEnvIntOrDefault("LOGIN_MIN_LENGTH", *loginMinLength)
+	cfg.LoginMaxLength = getEnvIntOrDefault("LOGIN_MAX_LENGTH", *loginMaxLength)
+	cfg.LoginPattern = getEnvOrDefault("LOGIN_PATTERN", *loginPattern)
+	cfg.PasswordMinLength = getEnvIntOrDefault("PASSWORD_MIN_LENGTH", *passwordMinLength)
+	cfg.PasswordMinClasses = getEnvIntOrDefault("PASSWORD_MIN_CLASSES", *passwordMinClasses)
+	cfg.PasswordDenyListFile = getEnvOrDefault("PASSWORD_DENYLIST_FILE", *passwordDenyListFile)
+	cfg.OIDCProvidersFile = getEnvOrDefault("OIDC_PROVIDERS_FILE", *oidcProvidersFile)
+	cfg.OIDCStateTTL = getEnvDurationOrDefault("OIDC_STATE_TTL", *oidcStateTTL)
+	cfg.RateLimits = getEnvOrDefault("RATE_LIMITS", *rateLimits)
+	cfg.RateLimitStore = getEnvOrDefault("RATE_LIMIT_STORE", *rateLimitStore)
+	cfg.RateLimitFailureMode = getEnvOrDefault("RATE_LIMIT_FAILURE_MODE", *rateLimitFailureMode)
+	cfg.TrustedProxies = getEnvOrDefault("TRUSTED_PROXIES", *trustedProxies)
+	cfg.ForwardedHeader = getEnvOrDefault("FORWARDED_HEADER", *forwardedHeader)
 
 	return cfg
 }
@@ -43,3 +152,30 @@ func getEnvOrDefault(envName, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvIntOrDefault(envName string, defaultValue int) int {
+	if envValue := os.Getenv(envName); envValue != "" {
+		if value, err := strconv.Atoi(envValue); err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloatOrDefault(envName string, defaultValue float64) float64 {
+	if envValue := os.Getenv(envName); envValue != "" {
+		if value, err := strconv.ParseFloat(envValue, 64); err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDurationOrDefault(envName string, defaultValue time.Duration) time.Duration {
+	if envValue := os.Getenv(envName); envValue != "" {
+		if value, err := time.ParseDuration(envValue); err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	subscriberBuffer = 64
+	replayLimit      = 1000
+	retention        = 24 * time.Hour
+	reconnectDelay   = 2 * time.Second
+	// ReorderWindow bounds how long an event can stay invisible after its ID
+	// was assigned: every transaction that emits events runs under a 3s
+	// timeout. Replays look back this far so that events committed out of ID
+	// order are not skipped.
+	ReorderWindow = 10 * time.Second
+)
+
+type Hub struct {
+	storage     *repository.DBStorage
+	mu          sync.RWMutex
+	subscribers map[int]map[chan repository.UserEvent]struct{}
+	// lastID is the highest event ID dispatched, used to catch up after the
+	// listener reconnects. It is only touched by the Run goroutine.
+	lastID int64
+}
+
+func NewHub(storage *repository.DBStorage) *Hub {
+	return &Hub{
+		storage:     storage,
+		subscribers: make(map[int]map[chan repository.UserEvent]struct{}),
+	}
+}
+
+func (h *Hub) Run(ctx context.Context) {
+	go h.cleanup(ctx)
+
+	for {
+		err := h.storage.ListenUserEvents(ctx, h.catchUp, h.dispatch)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Log.Error("user events listener stopped, reconnecting", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (h *Hub) Subscribe(userID int) (<-chan repository.UserEvent, func()) {
+	ch := make(chan repository.UserEvent, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan repository.UserEvent]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[userID][ch]; ok {
+			delete(h.subscribers[userID], ch)
+			close(ch)
+		}
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+// Replay passes the user's events after afterID to emit in pages, so a
+// backlog larger than one page is delivered in full. Events committed
+// within ReorderWindow of afterID are included even when their IDs are
+// lower, so a resuming client may see a few of them again.
+func (h *Hub) Replay(userID int, afterID int64, emit func(repository.UserEvent)) error {
+	lookback := ReorderWindow
+	for {
+		page, err := h.storage.GetUserEventsAfter(userID, afterID, lookback, replayLimit)
+		if err != nil {
+			return err
+		}
+		for _, event := range page {
+			emit(event)
+			afterID = max(afterID, event.ID)
+		}
+		if len(page) < replayLimit {
+			return nil
+		}
+		lookback = 0
+	}
+}
+
+// catchUp runs each time the listener starts. Notifications sent while it
+// was disconnected are lost, so events after the last dispatched one are
+// read back from the table; subscribers drop any they already have.
+func (h *Hub) catchUp() {
+	if h.lastID == 0 {
+		id, err := h.storage.LatestUserEventID()
+		if err != nil {
+			logger.Log.Error("failed to read latest user event", zap.Error(err))
+			return
+		}
+		h.lastID = id
+		return
+	}
+
+	afterID, lookback := h.lastID, ReorderWindow
+	for {
+		page, err := h.storage.GetEventsAfter(afterID, lookback, replayLimit)
+		if err != nil {
+			logger.Log.Error("failed to catch up on user events", zap.Error(err))
+			return
+		}
+		for _, event := range page {
+			h.publish(event)
+			afterID = max(afterID, event.ID)
+		}
+		if len(page) < replayLimit {
+			return
+		}
+		lookback = 0
+	}
+}
+
+func (h *Hub) dispatch(payload string) {
+	var event repository.UserEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		logger.Log.Error("failed to decode user event", zap.Error(err))
+		return
+	}
+
+	h.publish(event)
+}
+
+func (h *Hub) publish(event repository.UserEvent) {
+	h.lastID = max(h.lastID, event.ID)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			logger.Log.Warn("dropping slow event subscriber", zap.Int("user_id", event.UserID))
+			delete(h.subscribers[event.UserID], ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *Hub) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := h.storage.DeleteUserEventsBefore(time.Now().Add(-retention))
+			if err != nil {
+				logger.Log.Error("failed to delete old user events", zap.Error(err))
+				continue
+			}
+			logger.Log.Debug("deleted old user events", zap.Int64("count", deleted))
+		}
+	}
+}
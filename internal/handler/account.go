@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/mdflamingo/Gofermart/internal/clientip"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/service"
+	"go.uber.org/zap"
+)
+
+func GetProfileHandler(w http.ResponseWriter, r *http.Request, svc *service.AccountService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	profile, err := svc.GetProfile(userID)
+	if err != nil {
+		handleAccountError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func ExportAccountHandler(w http.ResponseWriter, r *http.Request, svc *service.AccountService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	export, err := svc.Export(userID)
+	if err != nil {
+		handleAccountError(w, err)
+		return
+	}
+
+	logger.Log.Info("account data exported", zap.Int("user_id", userID))
+	w.Header().Set("Content-Disposition", `attachment; filename="gophermart-export.json"`)
+	writeJSON(w, http.StatusOK, export)
+}
+
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request, svc *service.AccountService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := middleware.GetClaimsFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get token claims", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if err := svc.Delete(userID, claims.FamilyID, req, clientip.FromRequest(r)); err != nil {
+		handleAccountError(w, err)
+		return
+	}
+
+	clearTokenCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAccountError(w http.ResponseWriter, err error) {
+	if writeThrottled(w, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrWrongPassword):
+		logger.Log.Warn("account request with wrong password")
+		http.Error(w, "Password is incorrect", http.StatusForbidden)
+	case errors.Is(err, service.ErrReauthenticationRequired):
+		logger.Log.Warn("account request without a recent sign-in")
+		http.Error(w, "Sign in again to confirm", http.StatusForbidden)
+	case errors.Is(err, service.ErrTOTPRequired),
+		errors.Is(err, service.ErrInvalidTOTPCode):
+		logger.Log.Warn("account request with missing or invalid two-factor code")
+		http.Error(w, "Valid two-factor code required", http.StatusForbidden)
+	default:
+		logger.Log.Error("failed to process account request", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
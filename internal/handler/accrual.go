@@ -50,18 +50,14 @@ func (w *AccrualWorker) Start(ctx context.Context) {
 				}
 				json.Unmarshal(resp.Body(), &accrualResp)
 
-				err = w.storage.UpdateOrderStatus(order.ID, accrualResp.Status, accrualResp.Accrual)
+				changed, err := w.storage.UpdateOrderStatus(order.ID, accrualResp.Status, accrualResp.Accrual, repository.StatusSourceWorker)
 				if err != nil {
 					zap.L().Error("Failed to update order status", zap.Error(err))
 					continue
 				}
 
-				if accrualResp.Status == "PROCESSED" && accrualResp.Accrual > 0 {
-					zap.L().Info("Updating balance", zap.Float64("accrual", accrualResp.Accrual), zap.Int("userID", order.UserID))
-					err = w.storage.UpdateBalance(order.UserID, accrualResp.Accrual)
-					if err != nil {
-						zap.L().Error("Failed to update balance", zap.Error(err))
-					}
+				if changed && accrualResp.Status == "PROCESSED" && accrualResp.Accrual > 0 {
+					zap.L().Info("Credited accrual", zap.Float64("accrual", accrualResp.Accrual), zap.Int("userID", order.UserID))
 				}
 			}
 		}
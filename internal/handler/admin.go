@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mdflamingo/Gofermart/internal/clientip"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/service"
+	"go.uber.org/zap"
+)
+
+func AdminSearchUsersHandler(w http.ResponseWriter, r *http.Request, svc *service.AdminService) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	users, err := svc.SearchUsers(r.URL.Query().Get("q"), limit)
+	if err != nil {
+		handleAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, users)
+}
+
+func AdminGetUserHandler(w http.ResponseWriter, r *http.Request, svc *service.AdminService) {
+	userID, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := svc.GetUser(userID)
+	if err != nil {
+		handleAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+func AdminGetUserOrdersHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
+	userID, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	orders, err := svc.GetUserOrders(userID)
+	if err != nil {
+		handleAdminError(w, err)
+		return
+	}
+	if orders == nil {
+		orders = []models.OrdersResponse{}
+	}
+
+	writeJSON(w, http.StatusOK, orders)
+}
+
+func AdminGetUserBalanceHandler(w http.ResponseWriter, r *http.Request, svc *service.BalanceService) {
+	userID, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	balance, err := svc.GetBalance(userID)
+	if err != nil {
+		handleAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, balance)
+}
+
+func AdminGetUserWithdrawalsHandler(w http.ResponseWriter, r *http.Request, svc *service.BalanceService) {
+	userID, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	withdrawals, err := svc.GetWithdrawals(userID)
+	if err != nil {
+		handleAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, withdrawals)
+}
+
+func AdminGetBalanceAdjustmentsHandler(w http.ResponseWriter, r *http.Request, svc *service.AdminService) {
+	userID, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	adjustments, err := svc.GetBalanceAdjustments(userID)
+	if err != nil {
+		handleAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adjustments)
+}
+
+func AdminAdjustBalanceHandler(w http.ResponseWriter, r *http.Request, svc *service.AdminService) {
+	actorID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	userID, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.BalanceAdjustmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	adjustment, err := svc.AdjustBalance(actorID, userID, req, clientip.FromRequest(r))
+	if err != nil {
+		handleAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, adjustment)
+}
+
+func AdminSetUserRoleHandler(w http.ResponseWriter, r *http.Request, svc *service.AdminService) {
+	actorID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	userID, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.RoleChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := svc.SetRole(actorID, userID, req.Role); err != nil {
+		handleAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func targetUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || userID <= 0 {
+		logger.Log.Warn("invalid user ID", zap.String("id", chi.URLParam(r, "id")))
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+func handleAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidSearchRequest),
+		errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrInvalidAdjustment):
+		logger.Log.Warn("invalid admin request", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrBalanceNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrCannotChangeOwnRole),
+		errors.Is(err, service.ErrInsufficientFunds):
+		logger.Log.Warn("admin request conflict", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		logger.Log.Error("failed to process admin request", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/service"
+	"go.uber.org/zap"
+)
+
+func CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request, svc *service.APIKeyService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req models.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := svc.Create(userID, req)
+	if err != nil {
+		handleAPIKeyError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func GetAPIKeysHandler(w http.ResponseWriter, r *http.Request, svc *service.APIKeyService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := svc.List(userID)
+	if err != nil {
+		handleAPIKeyError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request, svc *service.APIKeyService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := svc.Revoke(userID, keyID); err != nil {
+		handleAPIKeyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAPIKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidAPIKeyRequest):
+		logger.Log.Warn("invalid API key request")
+		http.Error(w, "Name, known scopes and a future expiry are required", http.StatusBadRequest)
+	case errors.Is(err, service.ErrAPIKeyNotFound):
+		http.Error(w, "API key not found", http.StatusNotFound)
+	default:
+		logger.Log.Error("failed to process API key request", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/mdflamingo/Gofermart/internal/clientip"
 	"github.com/mdflamingo/Gofermart/internal/logger"
 	"github.com/mdflamingo/Gofermart/internal/middleware"
 	"github.com/mdflamingo/Gofermart/internal/models"
@@ -79,7 +80,7 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request, svc *service.Balanc
 		return
 	}
 
-	err = svc.Withdraw(userID, req.Order, req.Sum)
+	err = svc.Withdraw(userID, req.Order, req.Sum, req.TOTPCode, clientip.FromRequest(r))
 	if err != nil {
 		handleWithdrawError(w, err)
 		return
@@ -89,6 +90,10 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request, svc *service.Balanc
 }
 
 func handleWithdrawError(w http.ResponseWriter, err error) {
+	if writeThrottled(w, err) {
+		return
+	}
+
 	switch {
 	case errors.Is(err, service.ErrInvalidOrderNumber):
 		logger.Log.Warn("incorrect order number format")
@@ -96,6 +101,12 @@ func handleWithdrawError(w http.ResponseWriter, err error) {
 	case errors.Is(err, service.ErrBalanceNotFound):
 		logger.Log.Warn("balance not found")
 		http.Error(w, "Balance not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrTOTPRequired):
+		logger.Log.Warn("two-factor code required for withdrawal")
+		http.Error(w, "Two-factor code required", http.StatusForbidden)
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		logger.Log.Warn("invalid two-factor code for withdrawal")
+		http.Error(w, "Invalid two-factor code", http.StatusForbidden)
 	case errors.Is(err, service.ErrInsufficientFunds):
 		logger.Log.Warn("insufficient funds for withdrawal")
 		http.Error(w, "Insufficient funds", http.StatusPaymentRequired)
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mdflamingo/Gofermart/internal/clientip"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/service"
+	"go.uber.org/zap"
+)
+
+func OpenDisputeHandler(w http.ResponseWriter, r *http.Request, svc *service.DisputeService) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		logger.Log.Warn("invalid content type", zap.String("content_type", r.Header.Get("Content-Type")))
+		http.Error(w, "Invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("failed to read request body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var req models.DisputeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Log.Warn("failed to unmarshal request", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := svc.OpenDispute(userID, req, clientip.FromRequest(r))
+	if err != nil {
+		handleDisputeError(w, err)
+		return
+	}
+
+	logger.Log.Info("dispute opened", zap.Int("dispute_id", dispute.ID), zap.Int("user_id", userID))
+	writeJSON(w, http.StatusCreated, dispute)
+}
+
+func GetDisputesHandler(w http.ResponseWriter, r *http.Request, svc *service.DisputeService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	disputes, err := svc.GetUserDisputes(userID)
+	if err != nil {
+		logger.Log.Error("failed to get disputes", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, disputes)
+}
+
+func AdminGetDisputesHandler(w http.ResponseWriter, r *http.Request, svc *service.DisputeService) {
+	disputes, err := svc.GetDisputeQueue(r.URL.Query().Get("status"))
+	if err != nil {
+		handleDisputeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, disputes)
+}
+
+func AdminResolveDisputeHandler(w http.ResponseWriter, r *http.Request, svc *service.DisputeService) {
+	actorID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		logger.Log.Warn("invalid content type", zap.String("content_type", r.Header.Get("Content-Type")))
+		http.Error(w, "Invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	disputeID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Log.Warn("invalid dispute ID", zap.String("id", chi.URLParam(r, "id")))
+		http.Error(w, "Invalid dispute ID", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("failed to read request body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var req models.DisputeResolutionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Log.Warn("failed to unmarshal request", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	actor := fmt.Sprintf("user:%d", actorID)
+
+	dispute, err := svc.ResolveDispute(disputeID, req, actor, clientip.FromRequest(r))
+	if err != nil {
+		handleDisputeError(w, err)
+		return
+	}
+
+	logger.Log.Info("dispute resolved",
+		zap.Int("dispute_id", disputeID),
+		zap.String("action", req.Action),
+		zap.String("actor", actor))
+	writeJSON(w, http.StatusOK, dispute)
+}
+
+func handleDisputeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidDisputeRequest),
+		errors.Is(err, service.ErrInvalidDisputeStatus):
+		logger.Log.Warn("invalid dispute request", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrOrderNotFound):
+		logger.Log.Warn("disputed order not found")
+		http.Error(w, "Order not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrDisputeNotFound):
+		logger.Log.Warn("dispute not found")
+		http.Error(w, "Dispute not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrDisputeAlreadyOpen),
+		errors.Is(err, service.ErrDisputeClosed),
+		errors.Is(err, service.ErrInsufficientFunds):
+		logger.Log.Warn("dispute conflict", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrDisputeNotAllowed),
+		errors.Is(err, service.ErrInvalidDisputeResolution):
+		logger.Log.Warn("dispute action not allowed", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		logger.Log.Error("failed to process dispute", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	respJSON, err := json.Marshal(v)
+	if err != nil {
+		logger.Log.Error("failed to marshal response to JSON", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(respJSON)
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/events"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	heartbeatInterval = 15 * time.Second
+	sentIDsLimit      = 4096
+)
+
+func EventsHandler(w http.ResponseWriter, r *http.Request, hub *events.Hub) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Log.Error("streaming is not supported by response writer")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var lastID int64
+	if lastEventID != "" {
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || lastID < 0 {
+			logger.Log.Warn("invalid last event ID", zap.String("last_event_id", lastEventID))
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub, unsubscribe := hub.Subscribe(userID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Event IDs are not delivered in order, so duplicates from the replay
+	// and the hub's reconnect catch-up are dropped by ID rather than by
+	// comparing with the last one sent.
+	sent := newRecentIDs(sentIDsLimit)
+
+	if lastID > 0 {
+		err = hub.Replay(userID, lastID, func(event repository.UserEvent) {
+			if sent.add(event.ID) {
+				writeEvent(w, event)
+			}
+		})
+		if err != nil {
+			// The client reconnects with the last ID it received and the
+			// replay resumes from there.
+			logger.Log.Error("failed to replay user events", zap.Error(err))
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if !sent.add(event.ID) {
+				continue
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event repository.UserEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Payload)
+}
+
+// recentIDs remembers the last limit event IDs added to it.
+type recentIDs struct {
+	seen  map[int64]struct{}
+	order []int64
+	limit int
+}
+
+func newRecentIDs(limit int) *recentIDs {
+	return &recentIDs{seen: make(map[int64]struct{}, limit), limit: limit}
+}
+
+// add records id and reports whether it was not seen before.
+func (r *recentIDs) add(id int64) bool {
+	if _, ok := r.seen[id]; ok {
+		return false
+	}
+
+	if len(r.order) == r.limit {
+		delete(r.seen, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.seen[id] = struct{}{}
+	r.order = append(r.order, id)
+	return true
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/service"
+	"go.uber.org/zap"
+)
+
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/user/oidc"
+)
+
+func OIDCStartHandler(w http.ResponseWriter, r *http.Request, svc *service.OIDCService) {
+	startOIDC(w, r, svc, 0)
+}
+
+// OIDCLinkHandler starts the same flow for a signed-in user; the callback
+// then attaches the identity to that user instead of signing in.
+func OIDCLinkHandler(w http.ResponseWriter, r *http.Request, svc *service.OIDCService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	startOIDC(w, r, svc, userID)
+}
+
+func startOIDC(w http.ResponseWriter, r *http.Request, svc *service.OIDCService, linkUserID int) {
+	authURL, state, err := svc.Start(chi.URLParam(r, "provider"), linkUserID)
+	if err != nil {
+		handleOIDCError(w, err)
+		return
+	}
+
+	// The state cookie ties the callback to the browser that started the
+	// flow, so an attacker cannot complete their own login in a victim's
+	// browser.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		Path:     oidcStateCookiePath,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request, svc *service.OIDCService, tokens *service.TokenService, twoFactor *service.TwoFactorService) {
+	providerName := chi.URLParam(r, "provider")
+	query := r.URL.Query()
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", MaxAge: -1, Path: oidcStateCookiePath, HttpOnly: true})
+
+	if errCode := query.Get("error"); errCode != "" {
+		logger.Log.Warn("identity provider returned an error",
+			zap.String("provider", providerName),
+			zap.String("error", errCode),
+			zap.String("description", query.Get("error_description")))
+		http.Error(w, "Sign-in was cancelled or denied by the identity provider", http.StatusUnauthorized)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		logger.Log.Warn("oidc state does not match the browser", zap.String("provider", providerName))
+		http.Error(w, "Invalid or expired sign-in state", http.StatusBadRequest)
+		return
+	}
+
+	login, err := svc.Callback(providerName, state, query.Get("code"))
+	if err != nil {
+		handleOIDCError(w, err)
+		return
+	}
+
+	if login.Linked {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	mfaRequired, err := twoFactor.Enabled(login.UserID)
+	if err != nil {
+		logger.Log.Error("failed to check two-factor state", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if mfaRequired {
+		writeMFARequired(w, login.UserID, tokens)
+		return
+	}
+
+	issueTokens(w, r, login.UserID, tokens)
+}
+
+func handleOIDCError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUnknownProvider):
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidOIDCState):
+		logger.Log.Warn("invalid oidc state")
+		http.Error(w, "Invalid or expired sign-in state", http.StatusBadRequest)
+	case errors.Is(err, service.ErrOIDCIdentityRejected):
+		http.Error(w, "Identity could not be verified", http.StatusUnauthorized)
+	case errors.Is(err, service.ErrOIDCIdentityLinked):
+		http.Error(w, "Identity is linked to another account", http.StatusConflict)
+	case errors.Is(err, service.ErrOIDCProviderFailed):
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+	default:
+		logger.Log.Error("failed to process oidc sign-in", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mdflamingo/Gofermart/internal/oidc"
+	"github.com/mdflamingo/Gofermart/internal/oidc/oidctest"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"github.com/mdflamingo/Gofermart/internal/service"
+)
+
+// stateStore keeps pending sign-ins and counts how many were redeemed.
+type stateStore struct {
+	states   map[string]repository.OIDCState
+	consumed int
+}
+
+func (s *stateStore) SaveOIDCState(stateHash string, state repository.OIDCState, _ time.Time) error {
+	s.states[stateHash] = state
+	return nil
+}
+
+func (s *stateStore) ConsumeOIDCState(stateHash string) (repository.OIDCState, error) {
+	s.consumed++
+	state, ok := s.states[stateHash]
+	delete(s.states, stateHash)
+	if !ok {
+		return repository.OIDCState{}, repository.ErrNotFound
+	}
+	return state, nil
+}
+
+func (s *stateStore) ResolveIdentity(repository.ExternalIdentity) (int, bool, error) {
+	return 0, false, repository.ErrConflict
+}
+
+func newOIDCTestRouter(t *testing.T) (*chi.Mux, *stateStore) {
+	t.Helper()
+
+	idp := oidctest.NewServer(t, "gophermart")
+	provider, err := oidc.NewProvider(oidc.ProviderConfig{
+		Name:        "stub",
+		Issuer:      idp.URL,
+		ClientID:    "gophermart",
+		RedirectURL: "http://localhost/api/user/oidc/stub/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	policy, err := service.NewCredentialPolicy(service.CredentialPolicyConfig{})
+	if err != nil {
+		t.Fatalf("NewCredentialPolicy: %v", err)
+	}
+
+	store := &stateStore{states: make(map[string]repository.OIDCState)}
+	svc := service.NewOIDCService(store, map[string]*oidc.Provider{"stub": provider}, time.Minute, policy)
+
+	r := chi.NewRouter()
+	r.Get("/api/user/oidc/{provider}/start", func(w http.ResponseWriter, r *http.Request) {
+		OIDCStartHandler(w, r, svc)
+	})
+	r.Get("/api/user/oidc/{provider}/callback", func(w http.ResponseWriter, r *http.Request) {
+		OIDCCallbackHandler(w, r, svc, nil, nil)
+	})
+	return r, store
+}
+
+// startOIDCFlow begins a sign-in and returns the state cookie set on the
+// browser and the state sent to the provider.
+func startOIDCFlow(t *testing.T, r http.Handler) (*http.Cookie, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/user/oidc/stub/start", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("start status = %d, want %d", rec.Code, http.StatusFound)
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return cookie, location.Query().Get("state")
+		}
+	}
+	t.Fatal("start did not set the state cookie")
+	return nil, ""
+}
+
+func TestOIDCCallbackChecksStateCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie func(own *http.Cookie) *http.Cookie
+	}{
+		{name: "missing cookie", cookie: func(*http.Cookie) *http.Cookie { return nil }},
+		{name: "cookie from another flow", cookie: func(own *http.Cookie) *http.Cookie {
+			return &http.Cookie{Name: oidcStateCookie, Value: own.Value + "x"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, store := newOIDCTestRouter(t)
+			cookie, state := startOIDCFlow(t, r)
+
+			req := httptest.NewRequest(http.MethodGet,
+				"/api/user/oidc/stub/callback?code=code&state="+url.QueryEscape(state), nil)
+			if c := tt.cookie(cookie); c != nil {
+				req.AddCookie(c)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("callback status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			// The pending sign-in survives, so a forged callback cannot burn
+			// the victim's flow.
+			if store.consumed != 0 {
+				t.Errorf("state consumed %d times, want 0", store.consumed)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackAcceptsMatchingStateCookie(t *testing.T) {
+	r, store := newOIDCTestRouter(t)
+	cookie, state := startOIDCFlow(t, r)
+
+	// The stub never issued this code, so the exchange fails after the
+	// state has been checked and redeemed.
+	req := httptest.NewRequest(http.MethodGet,
+		"/api/user/oidc/stub/callback?code=unknown&state="+url.QueryEscape(state), nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("callback status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	if store.consumed != 1 {
+		t.Errorf("state consumed %d times, want 1", store.consumed)
+	}
+}
@@ -3,20 +3,24 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mdflamingo/Gofermart/internal/logger"
 	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/models"
 	"github.com/mdflamingo/Gofermart/internal/service"
 	"go.uber.org/zap"
 )
 
 func UploadOrderHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
-	if r.Header.Get("Content-Type") != "text/plain" {
-		logger.Log.Warn("invalid content type", zap.String("content_type", r.Header.Get("Content-Type")))
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "text/plain" && contentType != "application/json" {
+		logger.Log.Warn("invalid content type", zap.String("content_type", contentType))
 		http.Error(w, "Invalid Content-Type", http.StatusUnsupportedMediaType)
 		return
 	}
@@ -35,9 +39,22 @@ func UploadOrderHandler(w http.ResponseWriter, r *http.Request, svc *service.Ord
 		return
 	}
 
-	orderNum := strings.TrimSpace(string(body))
+	var orderNum string
+	var meta *models.OrderMetadata
+	if contentType == "application/json" {
+		var req models.OrderUploadRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Log.Warn("failed to unmarshal request", zap.Error(err))
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		orderNum = strings.TrimSpace(req.Number)
+		meta = &req.OrderMetadata
+	} else {
+		orderNum = strings.TrimSpace(string(body))
+	}
 
-	_, err = svc.UploadOrder(orderNum, userID)
+	_, err = svc.UploadOrder(orderNum, userID, meta)
 	if err != nil {
 		handleOrderUploadError(w, orderNum, err)
 		return
@@ -47,11 +64,58 @@ func UploadOrderHandler(w http.ResponseWriter, r *http.Request, svc *service.Ord
 	w.WriteHeader(http.StatusAccepted)
 }
 
+func UploadReceiptHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
+	if r.Header.Get("Content-Type") != "text/plain" {
+		logger.Log.Warn("invalid content type", zap.String("content_type", r.Header.Get("Content-Type")))
+		http.Error(w, "Invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("failed to read request body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	orderNum, err := svc.UploadReceipt(string(body), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidReceipt) {
+			logger.Log.Warn("invalid fiscal receipt", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		handleOrderUploadError(w, orderNum, err)
+		return
+	}
+
+	respJSON, err := json.Marshal(models.ReceiptUploadResponse{Number: orderNum})
+	if err != nil {
+		logger.Log.Error("failed to marshal response to JSON", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(respJSON)
+}
+
 func handleOrderUploadError(w http.ResponseWriter, orderNum string, err error) {
 	switch {
 	case errors.Is(err, service.ErrInvalidOrderNumber):
 		logger.Log.Warn("incorrect order number format", zap.String("order", orderNum))
 		http.Error(w, "Incorrect order number format", http.StatusUnprocessableEntity)
+	case errors.Is(err, service.ErrInvalidOrderMetadata):
+		logger.Log.Warn("invalid order metadata", zap.String("order", orderNum), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
 	case errors.Is(err, service.ErrOrderAlreadyUploadedByUser):
 		logger.Log.Info("order already uploaded by user", zap.String("order", orderNum))
 		w.Header().Set("Content-Type", "text/plain")
@@ -65,6 +129,74 @@ func handleOrderUploadError(w http.ResponseWriter, orderNum string, err error) {
 	}
 }
 
+func UploadOrdersBatchHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" && contentType != "text/plain" {
+		logger.Log.Warn("invalid content type", zap.String("content_type", contentType))
+		http.Error(w, "Invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("failed to read request body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var orderNums []string
+	if contentType == "application/json" {
+		if err := json.Unmarshal(body, &orderNums); err != nil {
+			logger.Log.Warn("failed to unmarshal request", zap.Error(err))
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for i := range orderNums {
+			orderNums[i] = strings.TrimSpace(orderNums[i])
+		}
+	} else {
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				orderNums = append(orderNums, line)
+			}
+		}
+	}
+
+	results, err := svc.UploadOrders(orderNums, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrEmptyBatch):
+			logger.Log.Warn("empty order batch")
+			http.Error(w, "At least one order number is required", http.StatusBadRequest)
+		case errors.Is(err, service.ErrBatchTooLarge):
+			logger.Log.Warn("order batch too large", zap.Int("size", len(orderNums)))
+			http.Error(w, "Too many order numbers in batch", http.StatusRequestEntityTooLarge)
+		default:
+			logger.Log.Error("failed to save orders", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respJSON, err := json.Marshal(results)
+	if err != nil {
+		logger.Log.Error("failed to marshal response to JSON", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
+
 func GetOrdersHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
 	userID, err := middleware.GetUserIDFromRequest(r)
 	if err != nil {
@@ -99,13 +231,93 @@ func GetOrdersHandler(w http.ResponseWriter, r *http.Request, svc *service.Order
 }
 
 func GetOrderHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
-	_, err := middleware.GetUserIDFromRequest(r)
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	orderNum := chi.URLParam(r, "number")
+	if orderNum == "" {
+		logger.Log.Warn("order number is empty")
+		http.Error(w, "Order number is required", http.StatusBadRequest)
+		return
+	}
+
+	order, err := svc.GetOrder(orderNum, userID)
+	writeOrderInfo(w, orderNum, order, err)
+}
+
+func GetOrderHistoryHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	orderNum := chi.URLParam(r, "number")
+	history, err := svc.GetOrderHistory(orderNum, userID)
+	if err != nil {
+		if errors.Is(err, service.ErrOrderNotFound) {
+			logger.Log.Warn("order not found", zap.String("order", orderNum))
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		logger.Log.Error("failed to get order history", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respJSON, err := json.Marshal(history)
+	if err != nil {
+		logger.Log.Error("failed to marshal response to JSON", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
+
+func CancelOrderHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
 	if err != nil {
 		logger.Log.Warn("failed to get user ID", zap.Error(err))
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
 
+	orderNum := chi.URLParam(r, "number")
+	err = svc.CancelOrder(orderNum, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			logger.Log.Warn("order not found", zap.String("order", orderNum))
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrOrderNotCancellable):
+			logger.Log.Warn("order cannot be cancelled", zap.String("order", orderNum))
+			http.Error(w, "Only new orders can be cancelled", http.StatusConflict)
+		default:
+			logger.Log.Error("failed to cancel order", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Log.Info("order cancelled", zap.String("order", orderNum), zap.Int("user_id", userID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func DeprecatedGetOrderHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", fmt.Sprintf(`</api/user/orders/%s>; rel="successor-version"`, url.PathEscape(chi.URLParam(r, "number"))))
+	GetOrderHandler(w, r, svc)
+}
+
+func AdminGetOrderHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderService) {
 	orderNum := chi.URLParam(r, "number")
 	if orderNum == "" {
 		logger.Log.Warn("order number is empty")
@@ -113,11 +325,15 @@ func GetOrderHandler(w http.ResponseWriter, r *http.Request, svc *service.OrderS
 		return
 	}
 
-	order, err := svc.GetOrder(orderNum)
+	order, err := svc.GetAnyOrder(orderNum)
+	writeOrderInfo(w, orderNum, order, err)
+}
+
+func writeOrderInfo(w http.ResponseWriter, orderNum string, order *models.OrderInfoResponse, err error) {
 	if err != nil {
 		if errors.Is(err, service.ErrOrderNotFound) {
 			logger.Log.Warn("order not found", zap.String("order", orderNum))
-			w.WriteHeader(http.StatusNoContent)
+			http.Error(w, "Order not found", http.StatusNotFound)
 			return
 		}
 		logger.Log.Error("failed to get order", zap.Error(err))
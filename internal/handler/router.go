@@ -1,23 +1,149 @@
 package handler
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mdflamingo/Gofermart/internal/clientip"
 	"github.com/mdflamingo/Gofermart/internal/config"
+	"github.com/mdflamingo/Gofermart/internal/events"
+	"github.com/mdflamingo/Gofermart/internal/keyring"
 	"github.com/mdflamingo/Gofermart/internal/logger"
 	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/notifier"
+	"github.com/mdflamingo/Gofermart/internal/oidc"
+	"github.com/mdflamingo/Gofermart/internal/ratelimit"
 	"github.com/mdflamingo/Gofermart/internal/repository"
 	"github.com/mdflamingo/Gofermart/internal/service"
 )
 
-func NewRouter(conf *config.Config, storage *repository.DBStorage, worker *AccrualWorker) *chi.Mux {
+func NewRouter(conf *config.Config, storage *repository.DBStorage, worker *AccrualWorker, hub *events.Hub) (*chi.Mux, error) {
 	r := chi.NewRouter()
 
-	orderService := service.NewOrderService(storage)
-	balanceService := service.NewBalanceService(storage)
-	userService := service.NewUserService(storage)
+	orderValidator, err := service.ParseOrderNumberValidators(conf.OrderValidators)
+	if err != nil {
+		return nil, err
+	}
 
+	receiptLocation, err := time.LoadLocation(conf.ReceiptTimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid receipt time zone: %w", err)
+	}
+
+	var loginGuardFailOpen bool
+	switch conf.LoginGuardFailure {
+	case "open":
+		loginGuardFailOpen = true
+	case "closed":
+	default:
+		return nil, fmt.Errorf("unknown login guard failure mode %q", conf.LoginGuardFailure)
+	}
+
+	loginGuard := service.NewLoginGuard(storage, service.LoginGuardConfig{
+		FreeAttempts:  conf.LoginFreeAttempts,
+		MaxAttempts:   conf.LoginMaxAttempts,
+		IPMaxAttempts: conf.LoginIPMaxAttempts,
+		Lockout:       conf.LoginLockout,
+		Window:        conf.LoginFailureWindow,
+		FailOpen:      loginGuardFailOpen,
+	})
+
+	orderService := service.NewOrderService(storage, orderValidator, receiptLocation)
+	twoFactorService := service.NewTwoFactorService(storage, loginGuard, conf.TOTPIssuer)
+	balanceService := service.NewBalanceService(storage, orderValidator, twoFactorService, conf.WithdrawTOTPThreshold, conf.WithdrawTOTPWindow)
+	if conf.Argon2Memory < 1 || int64(conf.Argon2Memory) > math.MaxUint32 ||
+		conf.Argon2Time < 1 || int64(conf.Argon2Time) > math.MaxUint32 ||
+		conf.Argon2Threads < 1 || conf.Argon2Threads > math.MaxUint8 {
+		return nil, fmt.Errorf("argon2 memory and iterations must be in 1..%d, threads in 1..%d",
+			uint32(math.MaxUint32), math.MaxUint8)
+	}
+	hasher, err := service.NewPasswordHasher(service.Argon2Params{
+		Memory:      uint32(conf.Argon2Memory),
+		Iterations:  uint32(conf.Argon2Time),
+		Parallelism: uint8(conf.Argon2Threads),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resetNotifier, err := notifier.New(conf.ResetNotifier)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialPolicy, err := service.NewCredentialPolicy(service.CredentialPolicyConfig{
+		LoginMinLength:     conf.LoginMinLength,
+		LoginMaxLength:     conf.LoginMaxLength,
+		LoginPattern:       conf.LoginPattern,
+		PasswordMinLength:  conf.PasswordMinLength,
+		PasswordMinClasses: conf.PasswordMinClasses,
+		DenyListFile:       conf.PasswordDenyListFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	userService := service.NewUserService(storage, hasher, resetNotifier, conf.ResetTokenTTL, credentialPolicy)
+	keys := keyring.FromSecret(conf.CookieSecretKey)
+	if conf.JWTKeysFile != "" {
+		if keys, err = keyring.Load(conf.JWTKeysFile); err != nil {
+			return nil, err
+		}
+	}
+
+	tokenService := service.NewTokenService(storage, keys, conf.AccessTokenTTL, conf.RefreshTokenTTL)
+	disputeService := service.NewDisputeService(storage)
+	apiKeyService := service.NewAPIKeyService(storage)
+	adminService := service.NewAdminService(storage)
+	accountService := service.NewAccountService(storage, hasher, orderService, balanceService, twoFactorService, loginGuard)
+	oidcProviders := map[string]*oidc.Provider{}
+	if conf.OIDCProvidersFile != "" {
+		if oidcProviders, err = oidc.LoadProviders(conf.OIDCProvidersFile); err != nil {
+			return nil, err
+		}
+	}
+
+	oidcService := service.NewOIDCService(storage, oidcProviders, conf.OIDCStateTTL, credentialPolicy)
+
+	rateLimits, err := ratelimit.ParsePolicies(conf.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	var rateLimitStore ratelimit.Store
+	switch conf.RateLimitStore {
+	case "memory":
+		rateLimitStore = ratelimit.NewMemoryStore()
+	case "postgres":
+		rateLimitStore = ratelimit.NewPostgresStore(storage)
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", conf.RateLimitStore)
+	}
+
+	var failOpen bool
+	switch conf.RateLimitFailureMode {
+	case "open":
+		failOpen = true
+	case "closed":
+	default:
+		return nil, fmt.Errorf("unknown rate limit failure mode %q", conf.RateLimitFailureMode)
+	}
+
+	limiter := ratelimit.NewLimiter(rateLimitStore, rateLimits).
+		WithKey(ratelimit.KeyUser, middleware.UserRateLimitKey).
+		WithKey(ratelimit.KeyAPIKey, middleware.APIKeyRateLimitKey).
+		WithFailOpen(failOpen)
+
+	clientIPResolver, err := clientip.NewResolver(conf.TrustedProxies, conf.ForwardedHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Use(clientIPResolver.Middleware)
 	r.Use(logger.RequestLogger)
 
 	r.Group(func(r chi.Router) {
@@ -25,37 +151,198 @@ func NewRouter(conf *config.Config, storage *repository.DBStorage, worker *Accru
 			DBHealthCheck(w, r, storage)
 		})
 
-		r.Post("/api/user/register", func(w http.ResponseWriter, r *http.Request) {
-			AuthorizationHandler(w, r, userService, conf.CookieSecretKey)
+		r.With(limiter.Limit("auth")).Post("/api/user/register", func(w http.ResponseWriter, r *http.Request) {
+			AuthorizationHandler(w, r, userService, tokenService)
+		})
+
+		r.With(limiter.Limit("auth")).Post("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+			AuthenticationHandler(w, r, userService, tokenService, loginGuard, twoFactorService)
+		})
+
+		r.With(limiter.Limit("auth")).Post("/api/user/login/2fa", func(w http.ResponseWriter, r *http.Request) {
+			LoginTOTPHandler(w, r, twoFactorService, tokenService)
+		})
+
+		r.With(limiter.Limit("auth")).Post("/api/user/token/refresh", func(w http.ResponseWriter, r *http.Request) {
+			RefreshTokenHandler(w, r, tokenService)
+		})
+
+		r.With(limiter.Limit("auth")).Get("/api/user/oidc/{provider}/start", func(w http.ResponseWriter, r *http.Request) {
+			OIDCStartHandler(w, r, oidcService)
+		})
+		r.With(limiter.Limit("auth")).Get("/api/user/oidc/{provider}/callback", func(w http.ResponseWriter, r *http.Request) {
+			OIDCCallbackHandler(w, r, oidcService, tokenService, twoFactorService)
 		})
 
-		r.Post("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
-			AuthenticationHandler(w, r, userService, conf.CookieSecretKey)
+		r.With(limiter.Limit("auth")).Post("/api/user/password/reset/request", func(w http.ResponseWriter, r *http.Request) {
+			PasswordResetRequestHandler(w, r, userService)
+		})
+		r.With(limiter.Limit("auth")).Post("/api/user/password/reset/confirm", func(w http.ResponseWriter, r *http.Request) {
+			PasswordResetConfirmHandler(w, r, userService)
 		})
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware(conf.CookieSecretKey))
+		r.Use(middleware.AuthMiddleware(tokenService, apiKeyService))
+		r.Use(limiter.Limit("api"))
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireSession)
 
-		r.Post("/api/user/orders", func(w http.ResponseWriter, r *http.Request) {
-			UploadOrderHandler(w, r, orderService)
+			r.Post("/api/user/logout", func(w http.ResponseWriter, r *http.Request) {
+				LogoutHandler(w, r, tokenService)
+			})
+			r.Post("/api/user/logout/all", func(w http.ResponseWriter, r *http.Request) {
+				LogoutEverywhereHandler(w, r, tokenService)
+			})
+			r.Get("/api/user/sessions", func(w http.ResponseWriter, r *http.Request) {
+				GetSessionsHandler(w, r, tokenService)
+			})
+			r.Delete("/api/user/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+				EndSessionHandler(w, r, tokenService)
+			})
+			r.Get("/api/user/me", func(w http.ResponseWriter, r *http.Request) {
+				GetProfileHandler(w, r, accountService)
+			})
+			r.Delete("/api/user/me", func(w http.ResponseWriter, r *http.Request) {
+				DeleteAccountHandler(w, r, accountService)
+			})
+			r.Get("/api/user/export", func(w http.ResponseWriter, r *http.Request) {
+				ExportAccountHandler(w, r, accountService)
+			})
+			r.Post("/api/user/password", func(w http.ResponseWriter, r *http.Request) {
+				ChangePasswordHandler(w, r, userService, tokenService)
+			})
+			r.Get("/api/user/oidc/{provider}/link", func(w http.ResponseWriter, r *http.Request) {
+				OIDCLinkHandler(w, r, oidcService)
+			})
+			r.Post("/api/user/2fa/enroll", func(w http.ResponseWriter, r *http.Request) {
+				EnrollTOTPHandler(w, r, twoFactorService)
+			})
+			r.Post("/api/user/2fa/confirm", func(w http.ResponseWriter, r *http.Request) {
+				ConfirmTOTPHandler(w, r, twoFactorService)
+			})
+			r.Post("/api/user/2fa/disable", func(w http.ResponseWriter, r *http.Request) {
+				DisableTOTPHandler(w, r, twoFactorService)
+			})
+			r.Post("/api/user/api-keys", func(w http.ResponseWriter, r *http.Request) {
+				CreateAPIKeyHandler(w, r, apiKeyService)
+			})
+			r.Get("/api/user/api-keys", func(w http.ResponseWriter, r *http.Request) {
+				GetAPIKeysHandler(w, r, apiKeyService)
+			})
+			r.Delete("/api/user/api-keys/{id}", func(w http.ResponseWriter, r *http.Request) {
+				RevokeAPIKeyHandler(w, r, apiKeyService)
+			})
+			r.Get("/api/user/events", func(w http.ResponseWriter, r *http.Request) {
+				EventsHandler(w, r, hub)
+			})
+			r.Post("/api/user/disputes", func(w http.ResponseWriter, r *http.Request) {
+				OpenDisputeHandler(w, r, disputeService)
+			})
+			r.Get("/api/user/disputes", func(w http.ResponseWriter, r *http.Request) {
+				GetDisputesHandler(w, r, disputeService)
+			})
 		})
-		r.Get("/api/user/orders", func(w http.ResponseWriter, r *http.Request) {
-			GetOrdersHandler(w, r, orderService)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(models.ScopeOrdersWrite))
+
+			r.Post("/api/user/orders", func(w http.ResponseWriter, r *http.Request) {
+				UploadOrderHandler(w, r, orderService)
+			})
+			r.Post("/api/user/orders/receipt", func(w http.ResponseWriter, r *http.Request) {
+				UploadReceiptHandler(w, r, orderService)
+			})
+			r.Post("/api/user/orders/batch", func(w http.ResponseWriter, r *http.Request) {
+				UploadOrdersBatchHandler(w, r, orderService)
+			})
+			r.Delete("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+				CancelOrderHandler(w, r, orderService)
+			})
 		})
-		r.Get("/api/user/balance", func(w http.ResponseWriter, r *http.Request) {
-			GetBalanceHandler(w, r, balanceService)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(models.ScopeOrdersRead))
+
+			r.Get("/api/user/orders", func(w http.ResponseWriter, r *http.Request) {
+				GetOrdersHandler(w, r, orderService)
+			})
+			r.With(limiter.Limit("orders.lookup")).Get("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+				GetOrderHandler(w, r, orderService)
+			})
+			r.Get("/api/user/orders/{number}/history", func(w http.ResponseWriter, r *http.Request) {
+				GetOrderHistoryHandler(w, r, orderService)
+			})
+			r.With(limiter.Limit("orders.lookup")).Get("/api/user/{number}", func(w http.ResponseWriter, r *http.Request) {
+				DeprecatedGetOrderHandler(w, r, orderService)
+			})
 		})
-		r.Post("/api/user/balance/withdraw", func(w http.ResponseWriter, r *http.Request) {
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(models.ScopeBalanceRead))
+
+			r.Get("/api/user/balance", func(w http.ResponseWriter, r *http.Request) {
+				GetBalanceHandler(w, r, balanceService)
+			})
+			r.Get("/api/user/withdrawals", func(w http.ResponseWriter, r *http.Request) {
+				GetWithdrawalsHandler(w, r, balanceService)
+			})
+		})
+
+		r.With(middleware.RequireScope(models.ScopeBalanceWrite)).Post("/api/user/balance/withdraw", func(w http.ResponseWriter, r *http.Request) {
 			WithdrawHandler(w, r, balanceService)
 		})
-		r.Get("/api/user/withdrawals", func(w http.ResponseWriter, r *http.Request) {
-			GetWithdrawalsHandler(w, r, balanceService)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware(tokenService, apiKeyService))
+		r.Use(middleware.RequireSession)
+		r.Use(middleware.RequireRole(models.RoleSupport, models.RoleAdmin))
+
+		r.Get("/api/admin/users", func(w http.ResponseWriter, r *http.Request) {
+			AdminSearchUsersHandler(w, r, adminService)
+		})
+		r.Get("/api/admin/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+			AdminGetUserHandler(w, r, adminService)
+		})
+		r.Get("/api/admin/users/{id}/orders", func(w http.ResponseWriter, r *http.Request) {
+			AdminGetUserOrdersHandler(w, r, orderService)
+		})
+		r.Get("/api/admin/users/{id}/balance", func(w http.ResponseWriter, r *http.Request) {
+			AdminGetUserBalanceHandler(w, r, balanceService)
+		})
+		r.Get("/api/admin/users/{id}/withdrawals", func(w http.ResponseWriter, r *http.Request) {
+			AdminGetUserWithdrawalsHandler(w, r, balanceService)
+		})
+		r.Get("/api/admin/users/{id}/balance/adjustments", func(w http.ResponseWriter, r *http.Request) {
+			AdminGetBalanceAdjustmentsHandler(w, r, adminService)
+		})
+		r.Get("/api/admin/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+			AdminGetOrderHandler(w, r, orderService)
+		})
+		r.Get("/api/admin/disputes", func(w http.ResponseWriter, r *http.Request) {
+			AdminGetDisputesHandler(w, r, disputeService)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireRole(models.RoleAdmin))
+
+			r.Post("/api/admin/users/{id}/balance/adjustments", func(w http.ResponseWriter, r *http.Request) {
+				AdminAdjustBalanceHandler(w, r, adminService)
+			})
+			r.Put("/api/admin/users/{id}/role", func(w http.ResponseWriter, r *http.Request) {
+				AdminSetUserRoleHandler(w, r, adminService)
+			})
+			r.Post("/api/admin/disputes/{id}/resolve", func(w http.ResponseWriter, r *http.Request) {
+				AdminResolveDisputeHandler(w, r, disputeService)
+			})
 		})
-		r.Get("/api/user/{number}", rateLimit(func(w http.ResponseWriter, r *http.Request) {
-			GetOrderHandler(w, r, orderService)
-		}))
 	})
 
-	return r
+	if err := limiter.Validate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
 }
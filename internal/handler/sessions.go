@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/service"
+	"go.uber.org/zap"
+)
+
+func GetSessionsHandler(w http.ResponseWriter, r *http.Request, tokens *service.TokenService) {
+	claims, err := middleware.GetClaimsFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get token claims", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := tokens.ListSessions(claims.UserID, claims.FamilyID)
+	if err != nil {
+		logger.Log.Error("failed to get sessions", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func EndSessionHandler(w http.ResponseWriter, r *http.Request, tokens *service.TokenService) {
+	claims, err := middleware.GetClaimsFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get token claims", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := chi.URLParam(r, "id")
+	if err := tokens.EndSession(claims.UserID, sessionID); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		logger.Log.Error("failed to end session", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.Info("session ended", zap.Int("user_id", claims.UserID), zap.String("session_id", sessionID))
+	if sessionID == claims.FamilyID {
+		clearTokenCookies(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/clientip"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/service"
+	"go.uber.org/zap"
+)
+
+func EnrollTOTPHandler(w http.ResponseWriter, r *http.Request, svc *service.TwoFactorService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := svc.Enroll(userID)
+	if err != nil {
+		handleTwoFactorError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request, svc *service.TwoFactorService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := svc.Confirm(userID, req.Code)
+	if err != nil {
+		handleTwoFactorError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func DisableTOTPHandler(w http.ResponseWriter, r *http.Request, svc *service.TwoFactorService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := svc.Disable(userID, req.Code, clientip.FromRequest(r)); err != nil {
+		handleTwoFactorError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func LoginTOTPHandler(w http.ResponseWriter, r *http.Request, svc *service.TwoFactorService, tokens *service.TokenService) {
+	var req models.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := tokens.VerifyMFAToken(req.MFAToken)
+	if err != nil {
+		logger.Log.Warn("invalid MFA token", zap.Error(err))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := svc.VerifyCode(userID, req.Code, clientip.FromRequest(r)); err != nil {
+		if errors.Is(err, service.ErrInvalidTOTPCode) || errors.Is(err, service.ErrTOTPRequired) {
+			logger.Log.Warn("invalid two-factor code at login", zap.Int("user_id", userID))
+			http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+		handleTwoFactorError(w, err)
+		return
+	}
+
+	issueTokens(w, r, userID, tokens)
+}
+
+func writeMFARequired(w http.ResponseWriter, userID int, tokens *service.TokenService) {
+	token, expiresAt, err := tokens.IssueMFAToken(userID)
+	if err != nil {
+		logger.Log.Error("failed to create MFA token", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.MFARequiredResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(time.Until(expiresAt).Seconds()),
+	})
+}
+
+func handleTwoFactorError(w http.ResponseWriter, err error) {
+	if writeThrottled(w, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled):
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+	case errors.Is(err, service.ErrTOTPNotEnrolled):
+		http.Error(w, "Two-factor authentication is not enrolled", http.StatusBadRequest)
+	case errors.Is(err, service.ErrTOTPNotEnabled):
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+	case errors.Is(err, service.ErrTOTPRequired):
+		http.Error(w, "Two-factor code required", http.StatusBadRequest)
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		logger.Log.Warn("invalid two-factor code")
+		http.Error(w, "Invalid two-factor code", http.StatusForbidden)
+	default:
+		logger.Log.Error("failed to process two-factor request", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -4,16 +4,22 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/mdflamingo/Gofermart/internal/clientip"
 	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/middleware"
 	"github.com/mdflamingo/Gofermart/internal/models"
 	"github.com/mdflamingo/Gofermart/internal/service"
 	"go.uber.org/zap"
 )
 
-func AuthorizationHandler(w http.ResponseWriter, r *http.Request, svc *service.UserService, secretKey string) {
+const refreshTokenCookie = "refresh_token"
+
+func AuthorizationHandler(w http.ResponseWriter, r *http.Request, svc *service.UserService, tokens *service.TokenService) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		logger.Log.Error("failed to read request body", zap.Error(err))
@@ -34,23 +40,15 @@ func AuthorizationHandler(w http.ResponseWriter, r *http.Request, svc *service.U
 		return
 	}
 
-	token, err := svc.GenerateToken(userID, secretKey)
-	if err != nil {
-		logger.Log.Error("failed to create JWT token", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
-	}
-
-	setTokenCookie(w, token)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	resp := models.AuthResponse{Token: token}
-	respJSON, _ := json.Marshal(resp)
-	w.Write(respJSON)
+	issueTokens(w, r, userID, tokens)
 }
 
 func handleRegistrationError(w http.ResponseWriter, err error, login string) {
+	if writeValidationError(w, err) {
+		logger.Log.Warn("registration rejected by credential policy", zap.String("login", login), zap.Error(err))
+		return
+	}
+
 	switch {
 	case errors.Is(err, service.ErrEmptyRequiredField):
 		logger.Log.Warn("empty required field", zap.String("login", login))
@@ -64,7 +62,7 @@ func handleRegistrationError(w http.ResponseWriter, err error, login string) {
 	}
 }
 
-func AuthenticationHandler(w http.ResponseWriter, r *http.Request, svc *service.UserService, secretKey string) {
+func AuthenticationHandler(w http.ResponseWriter, r *http.Request, svc *service.UserService, tokens *service.TokenService, guard *service.LoginGuard, twoFactor *service.TwoFactorService) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		logger.Log.Error("failed to read request body", zap.Error(err))
@@ -79,29 +77,62 @@ func AuthenticationHandler(w http.ResponseWriter, r *http.Request, svc *service.
 		return
 	}
 
+	ip := clientip.FromRequest(r)
+	attempt, err := guard.Begin(req.Login, ip)
+	if err != nil {
+		handleLoginError(w, err, req.Login)
+		return
+	}
+
 	userID, err := svc.Login(req)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			attempt.Fail()
+		} else {
+			attempt.Release()
+		}
 		handleLoginError(w, err, req.Login)
 		return
 	}
 
-	token, err := svc.GenerateToken(userID, secretKey)
+	attempt.Succeed()
+
+	mfaRequired, err := twoFactor.Enabled(userID)
 	if err != nil {
-		logger.Log.Error("failed to create JWT token", zap.Error(err))
+		logger.Log.Error("failed to check two-factor state", zap.Error(err))
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	if mfaRequired {
+		writeMFARequired(w, userID, tokens)
+		return
+	}
+
+	issueTokens(w, r, userID, tokens)
+}
 
-	setTokenCookie(w, token)
+// writeThrottled answers errors returned by the login guard and reports
+// whether err was one of them.
+func writeThrottled(w http.ResponseWriter, err error) bool {
+	var throttled *service.LoginThrottledError
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	resp := models.AuthResponse{Token: token}
-	respJSON, _ := json.Marshal(resp)
-	w.Write(respJSON)
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+	case errors.Is(err, service.ErrLoginGuardUnavailable):
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	default:
+		return false
+	}
+	return true
 }
 
 func handleLoginError(w http.ResponseWriter, err error, login string) {
+	if writeThrottled(w, err) {
+		return
+	}
+
 	switch {
 	case errors.Is(err, service.ErrEmptyRequiredField):
 		logger.Log.Warn("empty required field", zap.String("login", login))
@@ -115,14 +146,231 @@ func handleLoginError(w http.ResponseWriter, err error, login string) {
 	}
 }
 
-func setTokenCookie(w http.ResponseWriter, token string) {
+func RefreshTokenHandler(w http.ResponseWriter, r *http.Request, tokens *service.TokenService) {
+	var refreshToken string
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+		refreshToken = cookie.Value
+	} else if r.Header.Get("Content-Type") == "application/json" {
+		var req models.RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Log.Warn("invalid request body", zap.Error(err))
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		refreshToken = req.RefreshToken
+	}
+
+	pair, err := tokens.Refresh(refreshToken, clientInfo(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrRefreshTokenReused):
+			logger.Log.Warn("refresh token reuse detected, token family revoked", zap.Error(err))
+			clearTokenCookies(w)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case errors.Is(err, service.ErrInvalidRefreshToken):
+			logger.Log.Warn("invalid refresh token")
+			clearTokenCookies(w)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		default:
+			logger.Log.Error("failed to refresh token", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeTokenPair(w, pair)
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request, tokens *service.TokenService) {
+	claims, err := middleware.GetClaimsFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get token claims", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if err := tokens.Logout(claims); err != nil {
+		logger.Log.Error("failed to logout", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.Info("user logged out", zap.Int("user_id", claims.UserID))
+	clearTokenCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func LogoutEverywhereHandler(w http.ResponseWriter, r *http.Request, tokens *service.TokenService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if err := tokens.LogoutEverywhere(userID); err != nil {
+		logger.Log.Error("failed to logout everywhere", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.Info("user logged out everywhere", zap.Int("user_id", userID))
+	clearTokenCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request, svc *service.UserService, tokens *service.TokenService) {
+	userID, err := middleware.GetUserIDFromRequest(r)
+	if err != nil {
+		logger.Log.Warn("failed to get user ID", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := svc.ChangePassword(userID, req); err != nil {
+		if writeValidationError(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrEmptyPassword):
+			http.Error(w, "New password is required", http.StatusBadRequest)
+		case errors.Is(err, service.ErrWrongPassword):
+			logger.Log.Warn("password change with wrong current password", zap.Int("user_id", userID))
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		default:
+			logger.Log.Error("failed to change password", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Log.Info("password changed", zap.Int("user_id", userID))
+	issueTokens(w, r, userID, tokens)
+}
+
+func PasswordResetRequestHandler(w http.ResponseWriter, r *http.Request, svc *service.UserService) {
+	var req models.PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := svc.RequestPasswordReset(req.Login); err != nil {
+		if errors.Is(err, service.ErrEmptyRequiredField) {
+			http.Error(w, "Login is required", http.StatusBadRequest)
+			return
+		}
+		logger.Log.Error("failed to request password reset", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func PasswordResetConfirmHandler(w http.ResponseWriter, r *http.Request, svc *service.UserService) {
+	var req models.PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Warn("invalid request body", zap.Error(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := svc.ConfirmPasswordReset(req); err != nil {
+		if writeValidationError(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrEmptyPassword):
+			http.Error(w, "New password is required", http.StatusBadRequest)
+		case errors.Is(err, service.ErrInvalidResetToken):
+			logger.Log.Warn("invalid password reset token")
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		default:
+			logger.Log.Error("failed to reset password", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	clearTokenCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeValidationError responds with the structured field errors when err
+// is a credential policy violation and reports whether it did so.
+func writeValidationError(w http.ResponseWriter, err error) bool {
+	var validationErr *service.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+
+	writeJSON(w, http.StatusBadRequest, models.ValidationErrorResponse{
+		Error:  "validation_failed",
+		Fields: validationErr.Fields,
+	})
+	return true
+}
+
+func issueTokens(w http.ResponseWriter, r *http.Request, userID int, tokens *service.TokenService) {
+	pair, err := tokens.IssueTokens(userID, clientInfo(r))
+	if err != nil {
+		logger.Log.Error("failed to create JWT token", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeTokenPair(w, pair)
+}
+
+func writeTokenPair(w http.ResponseWriter, pair *models.TokenPair) {
+	setTokenCookie(w, pair.AccessToken, pair.AccessExpiresAt)
+	setRefreshTokenCookie(w, pair.RefreshToken, pair.RefreshExpiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	resp := models.AuthResponse{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    int(time.Until(pair.AccessExpiresAt).Seconds()),
+	}
+	respJSON, _ := json.Marshal(resp)
+	w.Write(respJSON)
+}
+
+func setTokenCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token",
 		Value:    token,
 		HttpOnly: true,
 		Secure:   false,
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   int(30 * 24 * time.Hour.Seconds()),
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
 		Path:     "/",
 	})
 }
+
+func setRefreshTokenCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+		Path:     "/api/user",
+	})
+}
+
+func clearTokenCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "token", Value: "", MaxAge: -1, Path: "/", HttpOnly: true})
+	http.SetCookie(w, &http.Cookie{Name: refreshTokenCookie, Value: "", MaxAge: -1, Path: "/api/user", HttpOnly: true})
+}
@@ -2,64 +2,25 @@ package handler
 
 import (
 	"net/http"
-	"sync"
-	"time"
-)
+	"strings"
+	"unicode/utf8"
 
-var (
-	requestCounts = make(map[string][]time.Time)
-	mu            sync.RWMutex
-	limit         = 10
-	window        = time.Minute
+	"github.com/mdflamingo/Gofermart/internal/clientip"
+	"github.com/mdflamingo/Gofermart/internal/models"
 )
 
+const maxUserAgentLength = 512
 
-func init() {
-	go cleanupStaleEntries()
-}
-
-func cleanupStaleEntries() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		mu.Lock()
-		now := time.Now()
-		for ip, times := range requestCounts {
-			if len(times) == 0 || now.Sub(times[len(times)-1]) > window {
-				delete(requestCounts, ip)
-			}
+func clientInfo(r *http.Request) models.ClientInfo {
+	// Postgres rejects invalid UTF-8, so bad bytes are dropped before the
+	// value is cut to length on a rune boundary.
+	userAgent := strings.ToValidUTF8(r.UserAgent(), "")
+	if len(userAgent) > maxUserAgentLength {
+		cut := maxUserAgentLength
+		for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+			cut--
 		}
-		mu.Unlock()
-	}
-}
-
-func rateLimit(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-
-		mu.Lock()
-		defer mu.Unlock()
-
-		now := time.Now()
-
-		var valid []time.Time
-		for _, t := range requestCounts[ip] {
-			if now.Sub(t) <= window {
-				valid = append(valid, t)
-			}
-		}
-
-		if len(valid) >= limit {
-			w.Header().Set("Content-Type", "text/plain")
-			w.Header().Set("Retry-After", "60")
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte("No more than N requests per minute allowed"))
-			return
-		}
-
-		requestCounts[ip] = append(valid, now)
-
-		next.ServeHTTP(w, r)
+		userAgent = userAgent[:cut]
 	}
+	return models.ClientInfo{UserAgent: userAgent, IP: clientip.FromRequest(r)}
 }
@@ -0,0 +1,236 @@
+package keyring
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const LegacyKeyID = "default"
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrRetiredKey = errors.New("signing key has been retired")
+)
+
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   any
+	VerifyKey any
+	Retired   bool
+}
+
+type Keyring struct {
+	active  *Key
+	keys    map[string]*Key
+	methods []string
+}
+
+func New(activeID string, keys []*Key) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*Key, len(keys))}
+
+	methods := make(map[string]bool)
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("key ID is required")
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		k.keys[key.ID] = key
+		if !methods[key.Method.Alg()] {
+			methods[key.Method.Alg()] = true
+			k.methods = append(k.methods, key.Method.Alg())
+		}
+	}
+
+	active, ok := k.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q not found", activeID)
+	}
+	if active.Retired || active.SignKey == nil {
+		return nil, fmt.Errorf("active key %q cannot sign tokens", activeID)
+	}
+	k.active = active
+
+	return k, nil
+}
+
+func FromSecret(secret string) *Keyring {
+	key := &Key{
+		ID:        LegacyKeyID,
+		Method:    jwt.SigningMethodHS256,
+		SignKey:   []byte(secret),
+		VerifyKey: []byte(secret),
+	}
+	k, _ := New(LegacyKeyID, []*Key{key})
+	return k
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	return k.active.ID
+}
+
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.SignKey)
+}
+
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	options = append(options, jwt.WithValidMethods(k.methods))
+	return jwt.ParseWithClaims(tokenString, claims, k.keyfunc, options...)
+}
+
+func (k *Keyring) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = LegacyKeyID
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	if key.Retired {
+		return nil, fmt.Errorf("%w: %q", ErrRetiredKey, kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+	}
+
+	return key.VerifyKey, nil
+}
+
+type keyFile struct {
+	Active string      `json:"active"`
+	Keys   []keyConfig `json:"keys"`
+}
+
+type keyConfig struct {
+	ID             string `json:"id"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret,omitempty"`
+	SecretFile     string `json:"secret_file,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty"`
+	Retired        bool   `json:"retired,omitempty"`
+}
+
+// Load reads a keyring description from a JSON file. Relative key file paths
+// are resolved against the directory of the keyring file.
+func Load(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	keys := make([]*Key, 0, len(file.Keys))
+	for _, cfg := range file.Keys {
+		key, err := loadKey(dir, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %q: %w", cfg.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return New(file.Active, keys)
+}
+
+func loadKey(dir string, cfg keyConfig) (*Key, error) {
+	key := &Key{ID: cfg.ID, Retired: cfg.Retired}
+
+	switch strings.ToUpper(cfg.Alg) {
+	case "HS256", "HS384", "HS512":
+		key.Method = jwt.GetSigningMethod(strings.ToUpper(cfg.Alg))
+		secret := []byte(cfg.Secret)
+		if cfg.SecretFile != "" {
+			data, err := readKeyFile(dir, cfg.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			secret = []byte(strings.TrimSpace(string(data)))
+		}
+		if len(secret) == 0 {
+			return nil, errors.New("secret is required")
+		}
+		key.SignKey, key.VerifyKey = secret, secret
+
+	case "EDDSA", "ED25519":
+		key.Method = jwt.SigningMethodEdDSA
+		if cfg.PrivateKeyFile != "" {
+			data, err := readKeyFile(dir, cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := privateKey.(crypto.Signer)
+			if !ok {
+				return nil, errors.New("private key cannot sign")
+			}
+			key.SignKey, key.VerifyKey = signer, signer.Public()
+		} else if cfg.PublicKeyFile != "" {
+			data, err := readKeyFile(dir, cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key.VerifyKey, err = jwt.ParseEdPublicKeyFromPEM(data); err != nil {
+				return nil, err
+			}
+		}
+
+	case "RS256", "RS384", "RS512":
+		key.Method = jwt.GetSigningMethod(strings.ToUpper(cfg.Alg))
+		if cfg.PrivateKeyFile != "" {
+			data, err := readKeyFile(dir, cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.SignKey, key.VerifyKey = privateKey, &privateKey.PublicKey
+		} else if cfg.PublicKeyFile != "" {
+			data, err := readKeyFile(dir, cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key.VerifyKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", cfg.Alg)
+	}
+
+	if key.VerifyKey == nil {
+		return nil, errors.New("private_key_file or public_key_file is required")
+	}
+
+	return key, nil
+}
+
+func readKeyFile(dir, path string) ([]byte, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return os.ReadFile(path)
+}
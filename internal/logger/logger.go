@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/mdflamingo/Gofermart/internal/clientip"
 	"go.uber.org/zap"
 )
 
@@ -48,6 +49,12 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+func (r *loggingResponseWriter) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func RequestLogger(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -69,6 +76,7 @@ func RequestLogger(h http.Handler) http.Handler {
 		Log.Info("request completed",
 			zap.String("uri", r.RequestURI),
 			zap.String("method", r.Method),
+			zap.String("client_ip", clientip.FromRequest(r)),
 			zap.Int("status", responseData.status),
 			zap.Duration("duration", duration),
 			zap.Int("size", responseData.size),
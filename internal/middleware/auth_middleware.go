@@ -3,80 +3,186 @@ package middleware
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/service"
 	"go.uber.org/zap"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey contextKey = "userID"
+	claimsKey contextKey = "claims"
+)
+
+type TokenVerifier interface {
+	VerifyAccessToken(token string) (*models.AccessClaims, error)
+}
+
+type APIKeyVerifier interface {
+	VerifyAPIKey(key string) (*models.AccessClaims, error)
+}
+
+const (
+	authRealm    = "gophermart"
+	apiKeyHeader = "X-API-Key"
+)
 
-func AuthMiddleware(secretKey string) func(http.Handler) http.Handler {
+var errMalformedAuthorization = errors.New("malformed authorization header")
+
+// AuthMiddleware authenticates requests with an API key from the X-API-Key
+// header or with an access token taken from the Authorization: Bearer
+// header or, when the header carries no bearer token, from the token
+// cookie. Sending both an API key and an Authorization header is rejected
+// as ambiguous.
+func AuthMiddleware(verifier TokenVerifier, apiKeys APIKeyVerifier) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("token")
+			if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" {
+				if r.Header.Get("Authorization") != "" {
+					logger.Log.Warn("both API key and authorization header supplied")
+					writeAuthError(w, http.StatusBadRequest, "invalid_request", "Use either an API key or an access token")
+					return
+				}
+				authenticate(w, r, next, func() (*models.AccessClaims, error) {
+					return apiKeys.VerifyAPIKey(apiKey)
+				})
+				return
+			}
+
+			tokenString, err := accessTokenFromRequest(r)
 			if err != nil {
-				if errors.Is(err, http.ErrNoCookie) {
-					logger.Log.Debug("no auth cookie found")
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				if errors.Is(err, errMalformedAuthorization) {
+					logger.Log.Warn("malformed authorization header")
+					writeAuthError(w, http.StatusBadRequest, "invalid_request", "The Authorization header is malformed")
 					return
 				}
-				logger.Log.Error("error reading cookie", zap.Error(err))
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				logger.Log.Debug("no access token found")
+				writeAuthError(w, http.StatusUnauthorized, "", "")
 				return
 			}
 
-			tokenString := cookie.Value
-			userID, err := validateJWT(tokenString, secretKey)
+			authenticate(w, r, next, func() (*models.AccessClaims, error) {
+				return verifier.VerifyAccessToken(tokenString)
+			})
+		})
+	}
+}
+
+func authenticate(w http.ResponseWriter, r *http.Request, next http.Handler, verify func() (*models.AccessClaims, error)) {
+	claims, err := verify()
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidToken) || errors.Is(err, service.ErrTokenRevoked) ||
+			errors.Is(err, service.ErrInvalidAPIKey) {
+			logger.Log.Warn("invalid token", zap.Error(err))
+			writeAuthError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid, expired or revoked")
+			return
+		}
+		logger.Log.Error("failed to verify token", zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+	ctx = context.WithValue(ctx, claimsKey, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RequireScope rejects requests whose credentials do not grant scope with
+// 403 insufficient_scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := GetClaimsFromRequest(r)
 			if err != nil {
-				logger.Log.Warn("invalid token", zap.Error(err))
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				writeAuthError(w, http.StatusUnauthorized, "", "")
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), userIDKey, userID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			if !claims.HasScope(scope) {
+				logger.Log.Warn("insufficient scope",
+					zap.Int("user_id", claims.UserID),
+					zap.Int64("api_key_id", claims.APIKeyID),
+					zap.String("scope", scope))
+				writeScopeError(w, scope, "The API key does not grant the required scope")
+				return
+			}
+
+			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func validateJWT(tokenString, secretKey string) (int, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+// RequireSession restricts account management routes to user sessions so
+// that an API key cannot be used to mint broader credentials.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := GetClaimsFromRequest(r)
+		if err != nil {
+			writeAuthError(w, http.StatusUnauthorized, "", "")
+			return
 		}
-		return []byte(secretKey), nil
+
+		if claims.APIKeyID != 0 {
+			logger.Log.Warn("API key used for a session-only route",
+				zap.Int("user_id", claims.UserID),
+				zap.Int64("api_key_id", claims.APIKeyID))
+			writeScopeError(w, "", "This endpoint requires a user session")
+			return
+		}
+
+		next.ServeHTTP(w, r)
 	})
+}
 
-	if err != nil {
-		return 0, err
+func accessTokenFromRequest(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			token = strings.TrimSpace(token)
+			if token == "" || strings.ContainsAny(token, " \t") {
+				return "", errMalformedAuthorization
+			}
+			return token, nil
+		}
 	}
 
-	if !token.Valid {
-		return 0, errors.New("invalid token")
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return "", err
 	}
+	return cookie.Value, nil
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, errors.New("invalid token claims")
-	}
+func writeAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("WWW-Authenticate", authChallenge(code, description))
+	http.Error(w, http.StatusText(status), status)
+}
 
-	if exp, ok := claims["exp"].(float64); ok {
-		if time.Now().Unix() > int64(exp) {
-			return 0, errors.New("token expired")
-		}
+func writeScopeError(w http.ResponseWriter, scope, description string) {
+	challenge := authChallenge("insufficient_scope", description)
+	if scope != "" {
+		challenge += fmt.Sprintf(`, scope=%q`, scope)
 	}
 
-	userIDFloat, ok := claims["userID"].(float64)
-	if !ok {
-		return 0, errors.New("userID not found in token")
-	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
 
-	return int(userIDFloat), nil
+func authChallenge(code, description string) string {
+	challenge := fmt.Sprintf(`Bearer realm=%q`, authRealm)
+	if code != "" {
+		challenge += fmt.Sprintf(`, error=%q`, code)
+	}
+	if description != "" {
+		challenge += fmt.Sprintf(`, error_description=%q`, description)
+	}
+	return challenge
 }
 
 func GetUserIDFromRequest(r *http.Request) (int, error) {
@@ -93,3 +199,11 @@ func GetUserIDFromRequest(r *http.Request) (int, error) {
 
 	return userID, nil
 }
+
+func GetClaimsFromRequest(r *http.Request) (*models.AccessClaims, error) {
+	claims, ok := r.Context().Value(claimsKey).(*models.AccessClaims)
+	if !ok {
+		return nil, errors.New("token claims not found in context")
+	}
+	return claims, nil
+}
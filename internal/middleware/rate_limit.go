@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// UserRateLimitKey identifies the authenticated user for rate limiting.
+func UserRateLimitKey(r *http.Request) (string, bool) {
+	claims, err := GetClaimsFromRequest(r)
+	if err != nil {
+		return "", false
+	}
+	return strconv.Itoa(claims.UserID), true
+}
+
+// APIKeyRateLimitKey identifies the API key a request was made with, so
+// each of a user's machine clients gets its own budget.
+func APIKeyRateLimitKey(r *http.Request) (string, bool) {
+	claims, err := GetClaimsFromRequest(r)
+	if err != nil || claims.APIKeyID == 0 {
+		return "", false
+	}
+	return strconv.FormatInt(claims.APIKeyID, 10), true
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+// RequireRole allows the request through only when the role carried in the
+// access token is one of roles.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := GetClaimsFromRequest(r)
+			if err != nil {
+				writeAuthError(w, http.StatusUnauthorized, "", "")
+				return
+			}
+
+			if !slices.Contains(roles, claims.Role) {
+				logger.Log.Warn("insufficient role",
+					zap.Int("user_id", claims.UserID),
+					zap.String("role", claims.Role),
+					zap.Strings("required", roles))
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+const (
+	TierBronze   = "bronze"
+	TierSilver   = "silver"
+	TierGold     = "gold"
+	TierPlatinum = "platinum"
+)
+
+type ProfileResponse struct {
+	Login            string     `json:"login"`
+	Role             string     `json:"role"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastLogin        *time.Time `json:"last_login,omitempty"`
+	Tier             string     `json:"tier"`
+	LifetimePoints   float64    `json:"lifetime_points"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+}
+
+const (
+	LedgerAccrual    = "accrual"
+	LedgerWithdrawal = "withdrawal"
+	LedgerAdjustment = "adjustment"
+)
+
+type LedgerEntry struct {
+	Type      string    `json:"type"`
+	Reference string    `json:"reference"`
+	Amount    float64   `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AccountExport struct {
+	ExportedAt     time.Time           `json:"exported_at"`
+	Profile        ProfileResponse     `json:"profile"`
+	Orders         []OrdersResponse    `json:"orders"`
+	Withdrawals    []WithdrawnResponse `json:"withdrawals"`
+	BalanceHistory []LedgerEntry       `json:"balance_history"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	TOTPCode string `json:"totp_code,omitempty"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
+type AdminUserResponse struct {
+	ID    int    `json:"id"`
+	Login string `json:"login"`
+	Role  string `json:"role"`
+}
+
+type RoleChangeRequest struct {
+	Role string `json:"role"`
+}
+
+type BalanceAdjustmentRequest struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type BalanceAdjustmentResponse struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"user_id"`
+	Amount    float64   `json:"amount"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+const (
+	ScopeOrdersRead   = "orders:read"
+	ScopeOrdersWrite  = "orders:write"
+	ScopeBalanceRead  = "balance:read"
+	ScopeBalanceWrite = "balance:write"
+)
+
+var APIKeyScopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead, ScopeBalanceWrite}
+
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type APIKeyResponse struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type APIKeyCreatedResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+const (
+	DisputeActionRepoll   = "repoll"
+	DisputeActionReassign = "reassign"
+	DisputeActionCredit   = "credit"
+	DisputeActionReject   = "reject"
+)
+
+type DisputeRequest struct {
+	Order         string `json:"order"`
+	Message       string `json:"message"`
+	AttachmentRef string `json:"attachment_ref,omitempty"`
+}
+
+type DisputeResolutionRequest struct {
+	Action string  `json:"action"`
+	Amount float64 `json:"amount,omitempty"`
+	Note   string  `json:"note"`
+}
+
+type DisputeResponse struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"user_id,omitempty"`
+	Order         string     `json:"order"`
+	Reason        string     `json:"reason"`
+	Message       string     `json:"message"`
+	AttachmentRef string     `json:"attachment_ref,omitempty"`
+	Status        string     `json:"status"`
+	Resolution    string     `json:"resolution,omitempty"`
+	Note          string     `json:"resolution_note,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+}
@@ -2,11 +2,34 @@ package models
 
 import "time"
 
+type OrderItem struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity"`
+	Price    float64 `json:"price"`
+}
+
+type OrderMetadata struct {
+	StoreID     string      `json:"store_id,omitempty"`
+	Amount      *float64    `json:"amount,omitempty"`
+	PurchasedAt *time.Time  `json:"purchased_at,omitempty"`
+	Items       []OrderItem `json:"items,omitempty"`
+}
+
+type OrderUploadRequest struct {
+	Number string `json:"number"`
+	OrderMetadata
+}
+
+type ReceiptUploadResponse struct {
+	Number string `json:"number"`
+}
+
 type OrdersResponse struct {
 	Number     string    `json:"number"`
 	Status     string    `json:"status"`
 	Accrual    float64   `json:"accrual"`
 	UploadedAt time.Time `json:"uploaded_at"`
+	OrderMetadata
 }
 
 type OrderInfoResponse struct {
@@ -14,3 +37,21 @@ type OrderInfoResponse struct {
 	Status  string   `json:"status"`
 	Accrual *float64 `json:"accrual,omitempty"`
 }
+
+type OrderStatusChangeResponse struct {
+	Status    string    `json:"status"`
+	Source    string    `json:"source"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+const (
+	BatchOrderAccepted       = "accepted"
+	BatchOrderAlreadyYours   = "already_uploaded"
+	BatchOrderOwnedByAnother = "conflict"
+	BatchOrderInvalid        = "invalid"
+)
+
+type BatchOrderResult struct {
+	Number string `json:"number"`
+	Status string `json:"status"`
+}
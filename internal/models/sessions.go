@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+type AccessClaims struct {
+	UserID    int
+	TokenID   string
+	FamilyID  string
+	Version   int
+	ExpiresAt time.Time
+	Role      string
+	APIKeyID  int64
+	Scopes    []string
+}
+
+// HasScope reports whether the credentials grant scope. User sessions are
+// not scoped; only API keys are limited to the scopes they were issued with.
+func (c *AccessClaims) HasScope(scope string) bool {
+	if c.APIKeyID == 0 {
+		return true
+	}
+	return slices.Contains(c.Scopes, scope)
+}
+
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
@@ -9,6 +9,7 @@ type WithdrawnResponse struct {
 }
 
 type WithdrawnRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order    string  `json:"order"`
+	Sum      float64 `json:"sum"`
+	TOTPCode string  `json:"totp_code,omitempty"`
 }
@@ -0,0 +1,85 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, login, token string, expiresAt time.Time) error
+}
+
+// New creates a notifier from a spec: "log" writes messages to the
+// application log and "file:<path>" appends them as JSON lines to a file.
+func New(spec string) (Notifier, error) {
+	switch {
+	case spec == "" || spec == "log":
+		return LogNotifier{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if path == "" {
+			return nil, fmt.Errorf("file notifier requires a path")
+		}
+		return &FileNotifier{path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", spec)
+	}
+}
+
+type LogNotifier struct{}
+
+func (LogNotifier) SendPasswordReset(ctx context.Context, login, token string, expiresAt time.Time) error {
+	logger.Log.Info("password reset requested",
+		zap.String("login", login),
+		zap.String("token", token),
+		zap.Time("expires_at", expiresAt))
+	return nil
+}
+
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+type message struct {
+	Type      string    `json:"type"`
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+func (n *FileNotifier) SendPasswordReset(ctx context.Context, login, token string, expiresAt time.Time) error {
+	data, err := json.Marshal(message{
+		Type:      "password_reset",
+		Login:     login,
+		Token:     token,
+		ExpiresAt: expiresAt,
+		SentAt:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrDiscovery    = errors.New("oidc discovery failed")
+	ErrExchange     = errors.New("oidc code exchange failed")
+	ErrInvalidToken = errors.New("invalid id token")
+)
+
+// jwksRefreshInterval limits how often an unknown key ID triggers a JWKS
+// refetch, so forged tokens cannot make us hammer the provider.
+const jwksRefreshInterval = time.Minute
+
+type ProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes,omitempty"`
+	// LinkByEmail allows the first sign-in to attach to an existing account
+	// whose login equals the verified email. Only enable it for providers
+	// that own the email domain, such as the company SSO.
+	LinkByEmail bool `json:"link_by_email,omitempty"`
+}
+
+// Identity is what we learn about the user from a verified ID token.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	conf   ProviderConfig
+	client *resty.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]any
+	keysFetched time.Time
+}
+
+func NewProvider(conf ProviderConfig) (*Provider, error) {
+	if conf.Name == "" || conf.Issuer == "" || conf.ClientID == "" || conf.RedirectURL == "" {
+		return nil, errors.New("name, issuer, client_id and redirect_url are required")
+	}
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{"openid", "email", "profile"}
+	} else if !slices.Contains(conf.Scopes, "openid") {
+		conf.Scopes = append([]string{"openid"}, conf.Scopes...)
+	}
+	conf.Issuer = strings.TrimSuffix(conf.Issuer, "/")
+
+	return &Provider{
+		conf:   conf,
+		client: resty.New().SetTimeout(5 * time.Second),
+	}, nil
+}
+
+// LoadProviders reads a JSON array of provider configurations.
+func LoadProviders(path string) (map[string]*Provider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oidc providers: %w", err)
+	}
+
+	var configs []ProviderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse oidc providers: %w", err)
+	}
+
+	providers := make(map[string]*Provider, len(configs))
+	for _, conf := range configs {
+		if _, ok := providers[conf.Name]; ok {
+			return nil, fmt.Errorf("duplicate oidc provider %q", conf.Name)
+		}
+		provider, err := NewProvider(conf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oidc provider %q: %w", conf.Name, err)
+		}
+		providers[conf.Name] = provider
+	}
+
+	return providers, nil
+}
+
+func (p *Provider) Name() string {
+	return p.conf.Name
+}
+
+func (p *Provider) LinkByEmail() bool {
+	return p.conf.LinkByEmail
+}
+
+// AuthCodeURL builds the authorization request URL using PKCE with the S256
+// challenge method.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrDiscovery, err)
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.conf.ClientID)
+	query.Set("redirect_uri", p.conf.RedirectURL)
+	query.Set("scope", strings.Join(p.conf.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange redeems an authorization code and verifies the returned ID
+// token against the provider keys and the expected nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          code,
+		"redirect_uri":  p.conf.RedirectURL,
+		"code_verifier": verifier,
+	}
+
+	req := p.client.R().SetContext(ctx).SetFormData(form)
+	if p.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.conf.ClientID), url.QueryEscape(p.conf.ClientSecret))
+	} else {
+		req.SetFormData(map[string]string{"client_id": p.conf.ClientID})
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	resp, err := req.Post(meta.TokenEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("%w: token endpoint returned %d", ErrExchange, resp.StatusCode())
+	}
+	if err := json.Unmarshal(resp.Body(), &tokenResp); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+
+	return p.verifyIDToken(ctx, meta, tokenResp.IDToken, nonce)
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	AuthorizedBy  string `json:"azp"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, meta *metadata, raw, nonce string) (*Identity, error) {
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.key(ctx, meta, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.conf.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.conf.ClientID {
+		return nil, fmt.Errorf("%w: unexpected authorized party", ErrInvalidToken)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// isTrue accepts both boolean and string email_verified values, as some
+// providers send the latter.
+func isTrue(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta metadata
+	resp, err := p.client.R().SetContext(ctx).Get(p.conf.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("%w: discovery returned %d", ErrDiscovery, resp.StatusCode())
+	}
+	if err := json.Unmarshal(resp.Body(), &meta); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.conf.Issuer {
+		return nil, fmt.Errorf("%w: issuer mismatch %q", ErrDiscovery, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete provider metadata", ErrDiscovery)
+	}
+
+	p.meta = &meta
+	return p.meta, nil
+}
+
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx, meta.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookupKey finds a key by ID. Tokens without a kid are accepted only when
+// the provider publishes exactly one key.
+func (p *Provider) lookupKey(kid string) (any, bool) {
+	if kid == "" {
+		if len(p.keys) != 1 {
+			return nil, false
+		}
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) (map[string]any, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	resp, err := p.client.R().SetContext(ctx).Get(jwksURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("failed to fetch jwks: status %d", resp.StatusCode())
+	}
+	if err := json.Unmarshal(resp.Body(), &set); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we cannot use rather than failing the whole set.
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// CodeChallenge derives the S256 PKCE challenge from a code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
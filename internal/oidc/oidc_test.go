@@ -0,0 +1,161 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/oidc/oidctest"
+)
+
+const testClientID = "gophermart"
+
+func newTestProvider(t *testing.T, idp *oidctest.Server) *Provider {
+	t.Helper()
+
+	provider, err := NewProvider(ProviderConfig{
+		Name:        "stub",
+		Issuer:      idp.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost/api/user/oidc/stub/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return provider
+}
+
+// signIn runs the authorization request against the stub and returns the
+// code together with the verifier and nonce it was bound to.
+func signIn(t *testing.T, idp *oidctest.Server, provider *Provider, claims map[string]any) (code, verifier, nonce string) {
+	t.Helper()
+
+	verifier, nonce = "verifier-"+t.Name(), "nonce-"+t.Name()
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	return idp.Authorize(t, authURL, claims), verifier, nonce
+}
+
+func TestExchange(t *testing.T) {
+	idp := oidctest.NewServer(t, testClientID)
+	provider := newTestProvider(t, idp)
+
+	code, verifier, nonce := signIn(t, idp, provider, map[string]any{
+		"sub":            "alice",
+		"email":          "alice@example.com",
+		"email_verified": "true",
+		"name":           "Alice",
+	})
+
+	identity, err := provider.Exchange(context.Background(), code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := Identity{Subject: "alice", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	idp := oidctest.NewServer(t, testClientID)
+	provider := newTestProvider(t, idp)
+
+	code, _, nonce := signIn(t, idp, provider, nil)
+
+	_, err := provider.Exchange(context.Background(), code, "another-verifier", nonce)
+	if !errors.Is(err, ErrExchange) {
+		t.Fatalf("Exchange error = %v, want %v", err, ErrExchange)
+	}
+}
+
+func TestExchangeRejectsReusedCode(t *testing.T) {
+	idp := oidctest.NewServer(t, testClientID)
+	provider := newTestProvider(t, idp)
+
+	code, verifier, nonce := signIn(t, idp, provider, nil)
+	if _, err := provider.Exchange(context.Background(), code, verifier, nonce); err != nil {
+		t.Fatalf("first Exchange: %v", err)
+	}
+
+	_, err := provider.Exchange(context.Background(), code, verifier, nonce)
+	if !errors.Is(err, ErrExchange) {
+		t.Fatalf("second Exchange error = %v, want %v", err, ErrExchange)
+	}
+}
+
+func TestExchangeRejectsInvalidIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		nonce  string
+	}{
+		{name: "nonce mismatch", nonce: "another-nonce"},
+		{name: "wrong audience", claims: map[string]any{"aud": "another-client"}},
+		{name: "wrong issuer", claims: map[string]any{"iss": "https://idp.example.com"}},
+		{name: "expired", claims: map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "no subject", claims: map[string]any{"sub": ""}},
+		{
+			name:   "other authorized party",
+			claims: map[string]any{"aud": []string{testClientID, "another-client"}, "azp": "another-client"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := oidctest.NewServer(t, testClientID)
+			provider := newTestProvider(t, idp)
+
+			code, verifier, nonce := signIn(t, idp, provider, tt.claims)
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			_, err := provider.Exchange(context.Background(), code, verifier, nonce)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Exchange error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestExchangeRefreshesKeysForUnknownKeyID(t *testing.T) {
+	idp := oidctest.NewServer(t, testClientID)
+	provider := newTestProvider(t, idp)
+
+	code, verifier, nonce := signIn(t, idp, provider, nil)
+	if _, err := provider.Exchange(context.Background(), code, verifier, nonce); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if got := idp.JWKSRequests(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	idp.RotateKey(t)
+
+	// A refetch right after the last one is refused, so a stream of tokens
+	// with made-up key IDs cannot be used to flood the provider.
+	code, verifier, nonce = signIn(t, idp, provider, nil)
+	if _, err := provider.Exchange(context.Background(), code, verifier, nonce); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Exchange within refresh interval error = %v, want %v", err, ErrInvalidToken)
+	}
+	if got := idp.JWKSRequests(); got != 1 {
+		t.Fatalf("JWKS fetched %d times within refresh interval, want 1", got)
+	}
+
+	provider.mu.Lock()
+	provider.keysFetched = provider.keysFetched.Add(-jwksRefreshInterval)
+	provider.mu.Unlock()
+
+	code, verifier, nonce = signIn(t, idp, provider, nil)
+	if _, err := provider.Exchange(context.Background(), code, verifier, nonce); err != nil {
+		t.Fatalf("Exchange after key rotation: %v", err)
+	}
+	if got := idp.JWKSRequests(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+}
@@ -0,0 +1,211 @@
+// Package oidctest runs a minimal OpenID provider for tests: discovery,
+// JWKS and a token endpoint that enforces PKCE.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type signingKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+type grant struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+type Server struct {
+	*httptest.Server
+	ClientID string
+
+	mu           sync.Mutex
+	keys         []signingKey
+	keyCount     int
+	codes        map[string]grant
+	jwksRequests int
+}
+
+// NewServer starts a provider that accepts clientID and signs ID tokens
+// with a fresh RSA key. It is closed when the test ends.
+func NewServer(t testing.TB, clientID string) *Server {
+	t.Helper()
+
+	s := &Server{ClientID: clientID, codes: make(map[string]grant)}
+	s.RotateKey(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET /jwks", s.jwks)
+	mux.HandleFunc("POST /token", s.token)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// RotateKey replaces the signing key with a new one under a new key ID, as
+// a provider does when it rolls its keys.
+func (s *Server) RotateKey(t testing.TB) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyCount++
+	s.keys = []signingKey{{id: fmt.Sprintf("key-%d", s.keyCount), key: key}}
+}
+
+// JWKSRequests reports how many times the key set was fetched.
+func (s *Server) JWKSRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jwksRequests
+}
+
+// Authorize stands in for the user approving the request at authURL and
+// returns the code the provider would redirect back with. claims are added
+// to the ID token and override the defaults, so tests can forge iss, aud,
+// nonce or exp.
+func (s *Server) Authorize(t testing.TB, authURL string, claims map[string]any) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse authorization URL: %v", err)
+	}
+	query := u.Query()
+	if query.Get("client_id") != s.ClientID {
+		t.Fatalf("authorization request for client %q, want %q", query.Get("client_id"), s.ClientID)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without an S256 code challenge")
+	}
+
+	code := rand.Text()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[code] = grant{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		claims:    claims,
+	}
+	return code
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jwksRequests++
+
+	keys := make([]map[string]string, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, map[string]string{
+			"kid": k.id,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if user, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(user)
+	}
+	if clientID != s.ClientID {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := r.PostForm.Get("code")
+	g, ok := s.codes[code]
+	delete(s.codes, code)
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   s.URL,
+		"aud":   s.ClientID,
+		"sub":   "subject",
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": g.nonce,
+	}
+	for name, value := range g.claims {
+		claims[name] = value
+	}
+
+	signer := s.keys[len(s.keys)-1]
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = signer.id
+	idToken, err := token.SignedString(signer.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type bucketState struct {
+	tokens  float64
+	updated time.Time
+	expires time.Time
+}
+
+type windowState struct {
+	start    time.Time
+	current  int
+	previous int
+	expires  time.Time
+}
+
+// MemoryStore keeps limiter state in process. Each replica counts on its
+// own, so limits effectively multiply with the number of instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucketState
+	windows   map[string]*windowState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucketState),
+		windows: make(map[string]*windowState),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, policy Policy) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if policy.Algorithm == TokenBucket {
+		return s.takeToken(key, policy, now), nil
+	}
+	return s.takeWindow(key, policy, now), nil
+}
+
+func (s *MemoryStore) takeToken(key string, policy Policy, now time.Time) Result {
+	capacity := float64(policy.Capacity())
+	rate := float64(policy.Limit) / policy.Window.Seconds()
+
+	state, ok := s.buckets[key]
+	if !ok {
+		state = &bucketState{tokens: capacity, updated: now}
+		s.buckets[key] = state
+	}
+
+	state.tokens = math.Min(capacity, state.tokens+now.Sub(state.updated).Seconds()*rate)
+	state.updated = now
+
+	result := Result{Limit: policy.Capacity()}
+	if state.tokens >= 1 {
+		state.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - state.tokens) / rate)
+	}
+
+	result.Remaining = int(state.tokens)
+	result.Reset = secondsToDuration((capacity - state.tokens) / rate)
+	state.expires = now.Add(result.Reset)
+
+	return result
+}
+
+// takeWindow approximates a sliding window by weighting the previous fixed
+// window's count by how much of it still overlaps the sliding one.
+func (s *MemoryStore) takeWindow(key string, policy Policy, now time.Time) Result {
+	start := now.Truncate(policy.Window)
+
+	state, ok := s.windows[key]
+	if !ok {
+		state = &windowState{start: start}
+		s.windows[key] = state
+	}
+
+	if !state.start.Equal(start) {
+		if state.start.Add(policy.Window).Equal(start) {
+			state.previous = state.current
+		} else {
+			state.previous = 0
+		}
+		state.current = 0
+		state.start = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - elapsed.Seconds()/policy.Window.Seconds()
+	estimate := float64(state.previous)*weight + float64(state.current)
+
+	result := Result{Limit: policy.Limit, Reset: start.Add(policy.Window).Sub(now)}
+	if estimate+1 <= float64(policy.Limit) {
+		state.current++
+		estimate++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = windowRetryAfter(state, policy, elapsed)
+	}
+
+	result.Remaining = max(0, policy.Limit-int(math.Ceil(estimate)))
+	state.expires = start.Add(2 * policy.Window)
+
+	return result
+}
+
+// windowRetryAfter estimates when the weighted count drops enough to admit
+// one more request.
+func windowRetryAfter(state *windowState, policy Policy, elapsed time.Duration) time.Duration {
+	untilNext := policy.Window - elapsed
+	if state.current >= policy.Limit || state.previous == 0 {
+		return untilNext
+	}
+
+	needed := 1 - float64(policy.Limit-1-state.current)/float64(state.previous)
+	wait := time.Duration(needed*float64(policy.Window)) - elapsed
+	if wait <= 0 || wait > untilNext {
+		return untilNext
+	}
+	return wait
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, state := range s.buckets {
+		if now.After(state.expires) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, state := range s.windows {
+		if now.After(state.expires) {
+			delete(s.windows, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+const cleanupInterval = 5 * time.Minute
+
+// GCRABackend persists theoretical arrival times, see
+// repository.DBStorage.TakeRateLimit.
+type GCRABackend interface {
+	TakeRateLimit(key string, interval, tolerance time.Duration) (bool, time.Duration, error)
+	DeleteExpiredRateLimits() (int64, error)
+}
+
+// PostgresStore shares limiter state between replicas. Every policy is
+// enforced with GCRA, which admits the same sustained rate as either
+// in-memory algorithm and bursts of up to the policy's capacity.
+type PostgresStore struct {
+	backend     GCRABackend
+	lastCleanup atomic.Int64
+}
+
+func NewPostgresStore(backend GCRABackend) *PostgresStore {
+	s := &PostgresStore{backend: backend}
+	s.lastCleanup.Store(time.Now().UnixNano())
+	return s
+}
+
+func (s *PostgresStore) Take(_ context.Context, key string, policy Policy) (Result, error) {
+	s.cleanupIfDue()
+
+	interval := policy.Window / time.Duration(policy.Limit)
+	tolerance := interval * time.Duration(policy.Capacity())
+
+	allowed, ahead, err := s.backend.TakeRateLimit(key, interval, tolerance)
+	if err != nil {
+		return Result{}, err
+	}
+
+	ahead = max(ahead, 0)
+	result := Result{Allowed: allowed, Limit: policy.Capacity(), Reset: ahead}
+	if allowed {
+		result.Remaining = int((tolerance - ahead) / interval)
+	} else {
+		result.RetryAfter = max(ahead+interval-tolerance, 0)
+	}
+
+	return result, nil
+}
+
+// cleanupIfDue removes expired keys in the background at most once per
+// cleanupInterval per replica.
+func (s *PostgresStore) cleanupIfDue() {
+	last := s.lastCleanup.Load()
+	now := time.Now().UnixNano()
+	if time.Duration(now-last) < cleanupInterval || !s.lastCleanup.CompareAndSwap(last, now) {
+		return
+	}
+
+	go func() {
+		deleted, err := s.backend.DeleteExpiredRateLimits()
+		if err != nil {
+			logger.Log.Warn("failed to clean up rate limits", zap.Error(err))
+			return
+		}
+		logger.Log.Debug("cleaned up rate limits", zap.Int64("deleted", deleted))
+	}()
+}
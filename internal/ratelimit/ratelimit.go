@@ -0,0 +1,277 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/clientip"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	TokenBucket   = "token_bucket"
+	SlidingWindow = "sliding_window"
+)
+
+// Built-in key kinds. Callers may register more with Limiter.WithKey.
+const (
+	KeyIP     = "ip"
+	KeyUser   = "user"
+	KeyAPIKey = "api_key"
+)
+
+type Policy struct {
+	Name      string
+	Algorithm string
+	// Limit requests are allowed per Window. For a token bucket Limit is
+	// the refill amount and Burst the bucket size, which defaults to Limit.
+	Limit  int
+	Window time.Duration
+	Burst  int
+	Key    string
+}
+
+// Capacity is the most requests the policy ever admits at once.
+func (p Policy) Capacity() int {
+	if p.Algorithm == TokenBucket && p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Store applies a policy to a key and records the request when it is
+// allowed.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+// KeyFunc extracts the client identity a policy counts against. It returns
+// false when the request carries no such identity.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// ParsePolicies reads a spec such as
+// "orders.lookup=sliding_window?limit=10&window=1m&key=user", with entries
+// separated by ";". Each entry names a policy and configures its algorithm
+// with URL query parameters.
+func ParsePolicies(spec string) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, definition, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid rate limit policy %q", entry)
+		}
+
+		algorithm, rawParams, _ := strings.Cut(definition, "?")
+		params, err := url.ParseQuery(rawParams)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameters for rate limit policy %q: %w", name, err)
+		}
+
+		policy, err := parsePolicy(name, algorithm, params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit policy %q: %w", name, err)
+		}
+		policies[name] = policy
+	}
+
+	return policies, nil
+}
+
+func parsePolicy(name, algorithm string, params url.Values) (Policy, error) {
+	policy := Policy{Name: name, Algorithm: algorithm, Window: time.Minute, Key: KeyIP}
+
+	switch algorithm {
+	case TokenBucket, SlidingWindow:
+	default:
+		return Policy{}, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit < 1 {
+		return Policy{}, fmt.Errorf("invalid limit %q", params.Get("limit"))
+	}
+	policy.Limit = limit
+
+	if value := params.Get("window"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return Policy{}, fmt.Errorf("invalid window %q", value)
+		}
+		policy.Window = window
+	}
+
+	if value := params.Get("burst"); value != "" {
+		if algorithm != TokenBucket {
+			return Policy{}, fmt.Errorf("burst is only supported by %s", TokenBucket)
+		}
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst < 1 {
+			return Policy{}, fmt.Errorf("invalid burst %q", value)
+		}
+		policy.Burst = burst
+	}
+
+	if value := params.Get("key"); value != "" {
+		policy.Key = value
+	}
+
+	return policy, nil
+}
+
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+	keys     map[string]KeyFunc
+	failOpen bool
+	missing  []string
+}
+
+func NewLimiter(store Store, policies map[string]Policy) *Limiter {
+	return &Limiter{
+		store:    store,
+		policies: policies,
+		keys:     map[string]KeyFunc{KeyIP: clientIP},
+		failOpen: true,
+	}
+}
+
+// WithFailOpen sets whether requests are let through (true, the default)
+// or rejected with 503 when the store cannot be reached.
+func (l *Limiter) WithFailOpen(failOpen bool) *Limiter {
+	l.failOpen = failOpen
+	return l
+}
+
+// WithKey registers a key kind that policies can select with key=<name>.
+func (l *Limiter) WithKey(name string, fn KeyFunc) *Limiter {
+	l.keys[name] = fn
+	return l
+}
+
+// Validate reports policies that refer to unregistered key kinds and
+// policies that routes ask for but are not configured, so that
+// configuration mistakes fail at startup rather than leaving routes
+// unlimited. Call it once all routes are set up.
+func (l *Limiter) Validate() error {
+	for _, policy := range l.policies {
+		if _, ok := l.keys[policy.Key]; !ok {
+			return fmt.Errorf("rate limit policy %q uses unknown key %q", policy.Name, policy.Key)
+		}
+	}
+	if len(l.missing) > 0 {
+		return fmt.Errorf("rate limit policy %q is not configured", l.missing[0])
+	}
+	return nil
+}
+
+// Limit returns middleware enforcing the named policy. A name without a
+// configured policy is reported by Validate.
+func (l *Limiter) Limit(name string) func(http.Handler) http.Handler {
+	policy, ok := l.policies[name]
+	if !ok {
+		l.missing = append(l.missing, name)
+	}
+
+	return func(next http.Handler) http.Handler {
+		if !ok {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := l.key(r, policy.Key)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := l.store.Take(r.Context(), policy.Name+"|"+key, policy)
+			if err != nil {
+				logger.Log.Error("rate limit store failed",
+					zap.String("policy", policy.Name),
+					zap.Bool("fail_open", l.failOpen),
+					zap.Error(err))
+				if !l.failOpen {
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			writeHeaders(w, policy, result)
+
+			if !result.Allowed {
+				logger.Log.Warn("rate limit exceeded", zap.String("policy", policy.Name), zap.String("key", key))
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// keyFallbacks lets user and API key policies fall back to the next broader
+// identity so that anonymous requests are still limited.
+var keyFallbacks = map[string][]string{
+	KeyAPIKey: {KeyAPIKey, KeyUser, KeyIP},
+	KeyUser:   {KeyUser, KeyIP},
+}
+
+func (l *Limiter) key(r *http.Request, kind string) (string, bool) {
+	kinds, ok := keyFallbacks[kind]
+	if !ok {
+		kinds = []string{kind}
+	}
+
+	for _, kind := range kinds {
+		fn, ok := l.keys[kind]
+		if !ok {
+			continue
+		}
+		if key, ok := fn(r); ok {
+			return kind + ":" + key, true
+		}
+	}
+	return "", false
+}
+
+// writeHeaders sets the RateLimit header fields from the IETF httpapi
+// ratelimit-headers draft.
+func writeHeaders(w http.ResponseWriter, policy Policy, result Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Capacity(), ceilSeconds(policy.Window)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func clientIP(r *http.Request) (string, bool) {
+	ip := clientip.FromRequest(r)
+	return ip, ip != ""
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type UserProfile struct {
+	Login          string
+	Role           string
+	CreatedAt      time.Time
+	LastLoginAt    *time.Time
+	LifetimePoints float64
+}
+
+type LedgerEntry struct {
+	Type      string
+	Reference string
+	Amount    float64
+	CreatedAt time.Time
+}
+
+// GetUserProfile returns the profile of an active user. Lifetime points are
+// everything ever credited to the balance: the current amount plus what
+// has been withdrawn.
+func (d *DBStorage) GetUserProfile(userID int) (UserProfile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var profile UserProfile
+	err := d.pool.QueryRow(ctx,
+		`SELECT u.login, u.role, u.created_at, u.last_login_at,
+			COALESCE(b.current + b.withdrawn, 0)::float8
+		FROM users u LEFT JOIN balance b ON b.user_id = u.id
+		WHERE u.id = $1 AND u.deleted_at IS NULL`,
+		userID).Scan(&profile.Login, &profile.Role, &profile.CreatedAt, &profile.LastLoginAt, &profile.LifetimePoints)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserProfile{}, ErrNotFound
+		}
+		return UserProfile{}, fmt.Errorf("failed to get user profile: %w", err)
+	}
+	return profile, nil
+}
+
+func (d *DBStorage) UpdateLastLogin(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := d.pool.Exec(ctx, `UPDATE users SET last_login_at = NOW() WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update last login: %w", err)
+	}
+	return nil
+}
+
+// GetBalanceHistory returns every change to the user's balance in
+// chronological order: accruals of processed orders, withdrawals and
+// adjustments made by hand or by resolving disputes. The accrual of an
+// order that was reassigned after processing stays with the user it was
+// first taken from; the move itself is listed as adjustments.
+func (d *DBStorage) GetBalanceHistory(userID int) ([]LedgerEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT 'accrual', o.number, o.accrual::float8, COALESCE(h.changed_at, o.uploaded_at) AS created_at
+		FROM orders o
+		LEFT JOIN LATERAL (
+			SELECT changed_at FROM order_status_history
+			WHERE order_id = o.id AND status = 'PROCESSED'
+			ORDER BY changed_at LIMIT 1
+		) h ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT user_id FROM balance_adjustments
+			WHERE order_id = o.id AND amount < 0
+			ORDER BY created_at, id LIMIT 1
+		) earner ON TRUE
+		WHERE (o.user_id = $1 OR o.id IN (
+				SELECT order_id FROM balance_adjustments WHERE user_id = $1 AND amount < 0
+			))
+			AND COALESCE(earner.user_id, o.user_id) = $1
+			AND o.status = 'PROCESSED' AND o.accrual > 0
+		UNION ALL
+		SELECT 'withdrawal', "order", -sum::float8, processed_at::timestamptz
+		FROM withdrawals WHERE user_id = $1
+		UNION ALL
+		SELECT 'adjustment', reason, amount::float8, created_at
+		FROM balance_adjustments WHERE user_id = $1
+		ORDER BY created_at`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []LedgerEntry
+	for rows.Next() {
+		var entry LedgerEntry
+		if err := rows.Scan(&entry.Type, &entry.Reference, &entry.Amount, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// DeleteAccount anonymises the user while keeping the orders, withdrawals
+// and balance the ledger depends on. The login is replaced, the password
+// made unusable, every credential revoked and the client addresses kept on
+// sessions cleared. attemptKeys are the login_attempts keys that name the
+// user.
+func (d *DBStorage) DeleteAccount(userID int, attemptKeys []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE users SET login = 'deleted:' || id, login_normalized = NULL, password = '!', deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`,
+		userID)
+	if err != nil {
+		return fmt.Errorf("failed to anonymise user: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	if err := revokeAllTokens(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	statements := []string{
+		`UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		`DELETE FROM totp_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1`,
+		`DELETE FROM user_events WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM oidc_login_states WHERE link_user_id = $1`,
+		`UPDATE sessions SET ip = NULL, user_agent = NULL WHERE user_id = $1`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(ctx, stmt, userID); err != nil {
+			return fmt.Errorf("failed to delete account data: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM login_attempts WHERE key = ANY($1)`, attemptKeys); err != nil {
+		return fmt.Errorf("failed to delete login attempts: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type AdminUser struct {
+	ID    int
+	Login string
+	Role  string
+}
+
+type BalanceAdjustment struct {
+	ID        int64
+	UserID    int
+	Amount    float64
+	Reason    string
+	Actor     string
+	ActorIP   string
+	CreatedAt time.Time
+}
+
+// SearchUsers returns users whose login starts with prefix. The prefix is
+// matched literally; LIKE wildcards in it are escaped.
+func (d *DBStorage) SearchUsers(prefix string, limit int) ([]AdminUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT id, login, role FROM users
+		WHERE login ILIKE replace(replace(replace($1, '\', '\\'), '%', '\%'), '_', '\_') || '%'
+		ORDER BY login LIMIT $2`,
+		prefix, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []AdminUser
+	for rows.Next() {
+		var user AdminUser
+		if err := rows.Scan(&user.ID, &user.Login, &user.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (d *DBStorage) GetAdminUser(userID int) (AdminUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user AdminUser
+	err := d.pool.QueryRow(ctx,
+		`SELECT id, login, role FROM users WHERE id = $1`,
+		userID).Scan(&user.ID, &user.Login, &user.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AdminUser{}, ErrNotFound
+		}
+		return AdminUser{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// SetUserRole changes the role and revokes the user's tokens so that the
+// new role takes effect on the next login instead of at token expiry.
+func (d *DBStorage) SetUserRole(userID int, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET role = $2 WHERE id = $1`, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to set user role: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	if err := revokeAllTokens(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (d *DBStorage) GetUserIDByLogin(loginNormalized string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int
+	err := d.pool.QueryRow(ctx, `SELECT id FROM users WHERE login_normalized = $1`, loginNormalized).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to get user: %w", err)
+	}
+	return userID, nil
+}
+
+func (d *DBStorage) HasAdmin() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := d.pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE role = 'admin')`).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for admins: %w", err)
+	}
+	return exists, nil
+}
+
+// AdjustBalance applies a manual correction and records it in
+// balance_adjustments. Debits that would make the balance negative fail
+// with ErrInsufficientFunds.
+func (d *DBStorage) AdjustBalance(adjustment BalanceAdjustment) (BalanceAdjustment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return BalanceAdjustment{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var current float64
+	err = tx.QueryRow(ctx,
+		`SELECT current FROM balance WHERE user_id = $1 FOR UPDATE`,
+		adjustment.UserID).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BalanceAdjustment{}, ErrNotFound
+		}
+		return BalanceAdjustment{}, err
+	}
+
+	if current+adjustment.Amount < 0 {
+		return BalanceAdjustment{}, ErrInsufficientFunds
+	}
+
+	if err := adjustBalance(ctx, tx, adjustment.UserID, adjustment.Amount); err != nil {
+		return BalanceAdjustment{}, err
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO balance_adjustments (user_id, amount, reason, actor, actor_ip)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		adjustment.UserID, adjustment.Amount, adjustment.Reason, adjustment.Actor, adjustment.ActorIP,
+	).Scan(&adjustment.ID, &adjustment.CreatedAt)
+	if err != nil {
+		return BalanceAdjustment{}, fmt.Errorf("failed to save balance adjustment: %w", err)
+	}
+
+	return adjustment, tx.Commit(ctx)
+}
+
+func (d *DBStorage) GetBalanceAdjustments(userID int) ([]BalanceAdjustment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT id, user_id, amount, reason, actor, COALESCE(actor_ip, ''), created_at
+		FROM balance_adjustments WHERE user_id = $1 ORDER BY created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance adjustments: %w", err)
+	}
+	defer rows.Close()
+
+	var adjustments []BalanceAdjustment
+	for rows.Next() {
+		var a BalanceAdjustment
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Amount, &a.Reason, &a.Actor, &a.ActorIP, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+
+	return adjustments, rows.Err()
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type APIKey struct {
+	ID         int64
+	UserID     int
+	Name       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+const apiKeyLastUsedInterval = time.Minute
+
+func (d *DBStorage) SaveAPIKey(key APIKey, keyHash string) (APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := d.pool.QueryRow(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		key.UserID, key.Name, key.Prefix, keyHash, key.Scopes, key.ExpiresAt).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to save API key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (d *DBStorage) GetAPIKeys(userID int) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scopes,
+			&key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetActiveAPIKey returns the unrevoked, unexpired key with the given hash.
+// last_used_at is refreshed at most once per apiKeyLastUsedInterval to keep
+// authenticated requests from writing on every call.
+func (d *DBStorage) GetActiveAPIKey(keyHash string) (APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := d.pool.QueryRow(ctx,
+		`SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`,
+		keyHash).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scopes,
+		&key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, ErrNotFound
+		}
+		return APIKey{}, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > apiKeyLastUsedInterval {
+		_, err = d.pool.Exec(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, key.ID)
+		if err != nil {
+			return APIKey{}, fmt.Errorf("failed to update API key usage: %w", err)
+		}
+	}
+
+	return key, nil
+}
+
+func (d *DBStorage) RevokeAPIKey(id int64, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := d.pool.Exec(ctx,
+		`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1 AND user_id = $2`,
+		id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mdflamingo/Gofermart/internal/models"
+)
+
+var ErrDisputeClosed = errors.New("dispute is already closed")
+var ErrInvalidResolution = errors.New("resolution is not applicable to this dispute")
+
+const (
+	DisputeReasonInvalid        = "invalid"
+	DisputeReasonClaimedByOther = "claimed_by_other"
+)
+
+type Dispute struct {
+	ID             int
+	UserID         int
+	OrderID        int
+	OrderNumber    string
+	Reason         string
+	Message        string
+	AttachmentRef  string
+	Status         string
+	Resolution     string
+	ResolutionNote string
+	CreatedAt      time.Time
+	ResolvedAt     *time.Time
+}
+
+type ActiveOrder struct {
+	ID      int
+	UserID  int
+	Status  string
+	Accrual float64
+}
+
+type DisputeResolution struct {
+	Action  string
+	Amount  float64
+	Note    string
+	Actor   string
+	ActorIP string
+}
+
+const disputeColumns = `id, user_id, order_id, order_number, reason, message, COALESCE(attachment_ref, ''),
+	status, COALESCE(resolution, ''), COALESCE(resolution_note, ''), created_at, resolved_at`
+
+func scanDispute(row pgx.Row) (Dispute, error) {
+	var dispute Dispute
+	err := row.Scan(&dispute.ID, &dispute.UserID, &dispute.OrderID, &dispute.OrderNumber, &dispute.Reason,
+		&dispute.Message, &dispute.AttachmentRef, &dispute.Status, &dispute.Resolution, &dispute.ResolutionNote,
+		&dispute.CreatedAt, &dispute.ResolvedAt)
+	return dispute, err
+}
+
+func (d *DBStorage) GetActiveOrder(orderNum string) (ActiveOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order ActiveOrder
+	err := d.pool.QueryRow(ctx,
+		`SELECT id, user_id, status, accrual FROM orders WHERE number = $1 AND status <> 'CANCELLED'`,
+		orderNum).Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ActiveOrder{}, ErrNotFound
+		}
+		return ActiveOrder{}, err
+	}
+	return order, nil
+}
+
+func (d *DBStorage) CreateDispute(dispute Dispute, actorIP string) (Dispute, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return Dispute{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var attachmentRef any
+	if dispute.AttachmentRef != "" {
+		attachmentRef = dispute.AttachmentRef
+	}
+
+	created, err := scanDispute(tx.QueryRow(ctx,
+		`INSERT INTO disputes (user_id, order_id, order_number, reason, message, attachment_ref)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+disputeColumns,
+		dispute.UserID, dispute.OrderID, dispute.OrderNumber, dispute.Reason, dispute.Message, attachmentRef))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return Dispute{}, ErrConflict
+		}
+		return Dispute{}, fmt.Errorf("failed to save dispute: %w", err)
+	}
+
+	actor := fmt.Sprintf("user:%d", dispute.UserID)
+	if err := insertDisputeAudit(ctx, tx, created.ID, actor, actorIP, "opened", nil); err != nil {
+		return Dispute{}, err
+	}
+
+	return created, tx.Commit(ctx)
+}
+
+func (d *DBStorage) GetUserDisputes(userID int) ([]Dispute, error) {
+	return d.queryDisputes(`SELECT `+disputeColumns+` FROM disputes WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+}
+
+func (d *DBStorage) GetDisputes(status string) ([]Dispute, error) {
+	return d.queryDisputes(`SELECT `+disputeColumns+` FROM disputes WHERE status = $1 ORDER BY created_at`, status)
+}
+
+func (d *DBStorage) queryDisputes(query string, args ...any) ([]Dispute, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("database query error: %w", err)
+	}
+	defer rows.Close()
+
+	var disputes []Dispute
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("data scan error: %w", err)
+		}
+		disputes = append(disputes, dispute)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows processing error: %w", err)
+	}
+
+	return disputes, nil
+}
+
+func (d *DBStorage) ResolveDispute(disputeID int, resolution DisputeResolution) (Dispute, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return Dispute{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	dispute, err := scanDispute(tx.QueryRow(ctx,
+		`SELECT `+disputeColumns+` FROM disputes WHERE id = $1 FOR UPDATE`, disputeID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Dispute{}, ErrNotFound
+		}
+		return Dispute{}, err
+	}
+	if dispute.Status != "OPEN" {
+		return Dispute{}, ErrDisputeClosed
+	}
+
+	var ownerID int
+	var orderStatus string
+	var accrual float64
+	err = tx.QueryRow(ctx,
+		`SELECT user_id, status, accrual FROM orders WHERE id = $1 FOR UPDATE`,
+		dispute.OrderID).Scan(&ownerID, &orderStatus, &accrual)
+	if err != nil {
+		return Dispute{}, err
+	}
+
+	details := map[string]any{"action": resolution.Action, "note": resolution.Note}
+	status := "RESOLVED"
+
+	switch resolution.Action {
+	case models.DisputeActionRepoll:
+		// Only the owner can have their order re-polled; the accrual would
+		// otherwise go to someone who never asked for it.
+		if orderStatus != "INVALID" || ownerID != dispute.UserID {
+			return Dispute{}, ErrInvalidResolution
+		}
+		_, err = tx.Exec(ctx, `UPDATE orders SET status = 'NEW', accrual = 0 WHERE id = $1`, dispute.OrderID)
+		if err != nil {
+			return Dispute{}, err
+		}
+		if err := insertStatusChange(ctx, tx, dispute.OrderID, "NEW", StatusSourceAdmin); err != nil {
+			return Dispute{}, err
+		}
+		event := OrderStatusEvent{Number: dispute.OrderNumber, Status: "NEW"}
+		if err := insertUserEvent(ctx, tx, ownerID, EventOrderStatus, event); err != nil {
+			return Dispute{}, err
+		}
+
+	case models.DisputeActionReassign:
+		if ownerID == dispute.UserID || orderStatus == OrderStatusCancelled {
+			return Dispute{}, ErrInvalidResolution
+		}
+		_, err = tx.Exec(ctx, `UPDATE orders SET user_id = $1 WHERE id = $2`, dispute.UserID, dispute.OrderID)
+		if err != nil {
+			return Dispute{}, err
+		}
+		details["previous_owner_id"] = ownerID
+		if orderStatus == "PROCESSED" && accrual > 0 {
+			// The previous owner may have spent the accrual already; the
+			// admin has to settle that with a balance adjustment first.
+			var current float64
+			err = tx.QueryRow(ctx,
+				`SELECT current FROM balance WHERE user_id = $1 FOR UPDATE`,
+				ownerID).Scan(&current)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return Dispute{}, ErrNotFound
+				}
+				return Dispute{}, err
+			}
+			if current < accrual {
+				return Dispute{}, ErrInsufficientFunds
+			}
+			orderID := dispute.OrderID
+			if err := adjustDisputeBalance(ctx, tx, dispute, resolution, ownerID, -accrual, &orderID); err != nil {
+				return Dispute{}, err
+			}
+			if err := adjustDisputeBalance(ctx, tx, dispute, resolution, dispute.UserID, accrual, &orderID); err != nil {
+				return Dispute{}, err
+			}
+			details["transferred_accrual"] = accrual
+		}
+
+	case models.DisputeActionCredit:
+		if resolution.Amount <= 0 {
+			return Dispute{}, ErrInvalidResolution
+		}
+		if err := adjustDisputeBalance(ctx, tx, dispute, resolution, dispute.UserID, resolution.Amount, nil); err != nil {
+			return Dispute{}, err
+		}
+		details["amount"] = resolution.Amount
+
+	case models.DisputeActionReject:
+		status = "REJECTED"
+
+	default:
+		return Dispute{}, ErrInvalidResolution
+	}
+
+	dispute, err = scanDispute(tx.QueryRow(ctx,
+		`UPDATE disputes SET status = $1, resolution = $2, resolution_note = $3, resolved_at = NOW()
+		WHERE id = $4
+		RETURNING `+disputeColumns,
+		status, resolution.Action, resolution.Note, disputeID))
+	if err != nil {
+		return Dispute{}, err
+	}
+
+	err = insertDisputeAudit(ctx, tx, disputeID, resolution.Actor, resolution.ActorIP, resolution.Action, details)
+	if err != nil {
+		return Dispute{}, err
+	}
+
+	return dispute, tx.Commit(ctx)
+}
+
+// adjustDisputeBalance changes a balance like adjustBalance and records the
+// change in balance_adjustments, so that it appears in the user's balance
+// history. orderID is set when the change moves that order's accrual.
+func adjustDisputeBalance(ctx context.Context, tx pgx.Tx, dispute Dispute, resolution DisputeResolution, userID int, amount float64, orderID *int) error {
+	if err := adjustBalance(ctx, tx, userID, amount); err != nil {
+		return err
+	}
+
+	reason := resolution.Note
+	if reason == "" {
+		reason = fmt.Sprintf("dispute %d", dispute.ID)
+	}
+
+	_, err := tx.Exec(ctx,
+		`INSERT INTO balance_adjustments (user_id, amount, reason, actor, actor_ip, order_id)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID, amount, reason, resolution.Actor, resolution.ActorIP, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to save balance adjustment: %w", err)
+	}
+	return nil
+}
+
+func insertDisputeAudit(ctx context.Context, tx pgx.Tx, disputeID int, actor, actorIP, action string, details map[string]any) error {
+	var data []byte
+	if details != nil {
+		var err error
+		if data, err = json.Marshal(details); err != nil {
+			return fmt.Errorf("failed to marshal audit details: %w", err)
+		}
+	}
+
+	_, err := tx.Exec(ctx,
+		`INSERT INTO dispute_audit (dispute_id, actor, actor_ip, action, details) VALUES ($1, $2, $3, $4, $5)`,
+		disputeID, actor, actorIP, action, data)
+	if err != nil {
+		return fmt.Errorf("failed to save dispute audit: %w", err)
+	}
+	return nil
+}
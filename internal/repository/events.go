@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const UserEventsChannel = "user_events"
+
+const (
+	EventOrderStatus = "order_status"
+	EventBalance     = "balance"
+)
+
+type UserEvent struct {
+	ID        int64           `json:"id"`
+	UserID    int             `json:"user_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type OrderStatusEvent struct {
+	Number  string  `json:"number"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual"`
+}
+
+type BalanceEvent struct {
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+}
+
+func insertUserEvent(ctx context.Context, tx pgx.Tx, userID int, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event payload: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`WITH event AS (
+			INSERT INTO user_events (user_id, type, payload) VALUES ($1, $2, $3)
+			RETURNING id, user_id, type, payload, created_at
+		)
+		SELECT pg_notify($4, json_build_object(
+			'id', id, 'user_id', user_id, 'type', type, 'payload', payload, 'created_at', created_at
+		)::text) FROM event`,
+		userID, eventType, data, UserEventsChannel)
+	if err != nil {
+		return fmt.Errorf("failed to save user event: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserEventsAfter returns a page of the user's events with IDs above
+// afterID. With a positive lookback it also returns events with lower IDs
+// created within lookback of event afterID: IDs are assigned at insert but
+// become visible at commit, so such events may have committed after a
+// client saw afterID.
+func (d *DBStorage) GetUserEventsAfter(userID int, afterID int64, lookback time.Duration, limit int) ([]UserEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT id, user_id, type, payload, created_at FROM user_events
+		WHERE user_id = $1 AND (id > $2 OR ($3 > 0 AND id < $2 AND created_at > (
+			SELECT created_at - $3 * INTERVAL '1 second' FROM user_events WHERE id = $2
+		)))
+		ORDER BY id
+		LIMIT $4`,
+		userID, afterID, lookback.Seconds(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("database query error: %w", err)
+	}
+	defer rows.Close()
+
+	return scanUserEvents(rows)
+}
+
+// GetEventsAfter is GetUserEventsAfter across all users; the hub uses it to
+// catch up on notifications missed while it was not listening.
+func (d *DBStorage) GetEventsAfter(afterID int64, lookback time.Duration, limit int) ([]UserEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT id, user_id, type, payload, created_at FROM user_events
+		WHERE id > $1 OR ($2 > 0 AND id < $1 AND created_at > (
+			SELECT created_at - $2 * INTERVAL '1 second' FROM user_events WHERE id = $1
+		))
+		ORDER BY id
+		LIMIT $3`,
+		afterID, lookback.Seconds(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("database query error: %w", err)
+	}
+	defer rows.Close()
+
+	return scanUserEvents(rows)
+}
+
+func (d *DBStorage) LatestUserEventID() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := d.pool.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM user_events`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("database query error: %w", err)
+	}
+
+	return id, nil
+}
+
+func scanUserEvents(rows pgx.Rows) ([]UserEvent, error) {
+	var events []UserEvent
+	for rows.Next() {
+		var event UserEvent
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.Payload, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("data scan error: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows processing error: %w", err)
+	}
+
+	return events, nil
+}
+
+func (d *DBStorage) DeleteUserEventsBefore(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	commandTag, err := d.pool.Exec(ctx, `DELETE FROM user_events WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return commandTag.RowsAffected(), nil
+}
+
+// ListenUserEvents delivers user event notifications to handle until ctx
+// is done or the connection fails. onListen runs once LISTEN is in effect,
+// so anything committed before it can be caught up from the table.
+func (d *DBStorage) ListenUserEvents(ctx context.Context, onListen func(), handle func(payload string)) error {
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+UserEventsChannel); err != nil {
+		return fmt.Errorf("failed to listen for user events: %w", err)
+	}
+	onListen()
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(notification.Payload)
+	}
+}
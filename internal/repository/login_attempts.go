@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LoginReservation is the outcome of ReserveLoginAttempt. When RetryAfter is
+// non-zero the key was locked and nothing was reserved.
+type LoginReservation struct {
+	RetryAfter  time.Duration
+	Failures    int
+	LockedUntil *time.Time
+}
+
+// ReserveLoginAttempt counts an attempt against key as a failure before the
+// credentials are checked, so that parallel attempts cannot all pass a check
+// made before any of them failed. The lock that delay returns for the new count is
+// placed in the same transaction. Counters that saw no failures during window
+// start over.
+func (d *DBStorage) ReserveLoginAttempt(key string, window time.Duration, delay func(failures int) time.Duration) (LoginReservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return LoginReservation{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var reservation LoginReservation
+	var lockedSeconds *float64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.locked_until > NOW() THEN login_attempts.failures
+				WHEN login_attempts.last_failure_at < NOW() - $2 * INTERVAL '1 second' THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = CASE
+				WHEN login_attempts.locked_until > NOW() THEN login_attempts.last_failure_at
+				ELSE NOW()
+			END
+		RETURNING failures,
+			CASE WHEN locked_until > NOW() THEN EXTRACT(EPOCH FROM locked_until - NOW())::float8 END`,
+		key, window.Seconds()).Scan(&reservation.Failures, &lockedSeconds)
+	if err != nil {
+		return LoginReservation{}, fmt.Errorf("failed to reserve login attempt: %w", err)
+	}
+	if lockedSeconds != nil {
+		return LoginReservation{RetryAfter: time.Duration(*lockedSeconds * float64(time.Second))}, nil
+	}
+
+	if lock := delay(reservation.Failures); lock > 0 {
+		var lockedUntil time.Time
+		err = tx.QueryRow(ctx,
+			`UPDATE login_attempts SET locked_until = NOW() + $2 * INTERVAL '1 second' WHERE key = $1
+			RETURNING locked_until`,
+			key, lock.Seconds()).Scan(&lockedUntil)
+		if err != nil {
+			return LoginReservation{}, fmt.Errorf("failed to lock login: %w", err)
+		}
+		reservation.LockedUntil = &lockedUntil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return LoginReservation{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return reservation, nil
+}
+
+// ReleaseLoginAttempt gives back an attempt reserved on key. The lock the
+// reservation placed is lifted unless a later one has replaced it.
+func (d *DBStorage) ReleaseLoginAttempt(key string, lockedUntil *time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := d.pool.Exec(ctx,
+		`UPDATE login_attempts SET
+			failures = GREATEST(failures - 1, 0),
+			locked_until = CASE WHEN locked_until = $2 THEN NULL ELSE locked_until END
+		WHERE key = $1`,
+		key, lockedUntil)
+	if err != nil {
+		return fmt.Errorf("failed to release login attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DBStorage) ResetLoginAttempts(key string, window time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := d.pool.Exec(ctx,
+		`DELETE FROM login_attempts
+		WHERE key = $1
+			OR (last_failure_at < NOW() - $2 * INTERVAL '1 second' AND (locked_until IS NULL OR locked_until < NOW()))`,
+		key, window.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to reset login attempts: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mdflamingo/Gofermart/internal/models"
+)
+
+// NoPassword is stored as the password of accounts created through an
+// identity provider; it never matches a hash.
+const NoPassword = "!"
+
+type OIDCState struct {
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	LinkUserID   int
+}
+
+type ExternalIdentity struct {
+	Provider string
+	Subject  string
+	Email    string
+	// LinkUserID is the signed-in user starting a link, zero for a sign-in.
+	LinkUserID int
+	// EmailLogin is the normalized login an unknown identity may be
+	// attached to. It is empty unless the provider vouches for the email.
+	EmailLogin string
+	// NewLogins are tried in order for an account created on first
+	// sign-in, taking the first that is free. The password is ignored.
+	NewLogins []models.UserDB
+}
+
+func (d *DBStorage) SaveOIDCState(stateHash string, state OIDCState, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := d.pool.Exec(ctx, `DELETE FROM oidc_login_states WHERE expires_at < NOW()`)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired oidc states: %w", err)
+	}
+
+	var linkUserID *int
+	if state.LinkUserID != 0 {
+		linkUserID = &state.LinkUserID
+	}
+
+	_, err = d.pool.Exec(ctx,
+		`INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		stateHash, state.Provider, state.CodeVerifier, state.Nonce, linkUserID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save oidc state: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeOIDCState returns and deletes a pending login so that each state
+// can complete at most one callback.
+func (d *DBStorage) ConsumeOIDCState(stateHash string) (OIDCState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var state OIDCState
+	var linkUserID *int
+	err := d.pool.QueryRow(ctx,
+		`DELETE FROM oidc_login_states WHERE state_hash = $1 AND expires_at > NOW()
+		RETURNING provider, code_verifier, nonce, link_user_id`,
+		stateHash).Scan(&state.Provider, &state.CodeVerifier, &state.Nonce, &linkUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OIDCState{}, ErrNotFound
+		}
+		return OIDCState{}, err
+	}
+	if linkUserID != nil {
+		state.LinkUserID = *linkUserID
+	}
+
+	return state, nil
+}
+
+// ResolveIdentity maps an external identity to a user, linking or creating
+// one as needed, in this order: an identity linked earlier, the signed-in
+// user, an existing user whose login is the verified email, a new user. It
+// returns ErrConflict when the identity already belongs to someone other
+// than the user linking it.
+func (d *DBStorage) ResolveIdentity(identity ExternalIdentity) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx,
+		`UPDATE user_identities SET last_login_at = NOW(), email = $3
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_id`,
+		identity.Provider, identity.Subject, nullIfEmpty(identity.Email)).Scan(&userID)
+	if err == nil {
+		if identity.LinkUserID != 0 && identity.LinkUserID != userID {
+			return 0, false, ErrConflict
+		}
+		return userID, false, tx.Commit(ctx)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	created := false
+	switch {
+	case identity.LinkUserID != 0:
+		userID = identity.LinkUserID
+	case identity.EmailLogin != "":
+		err = tx.QueryRow(ctx,
+			`SELECT id FROM users WHERE login_normalized = $1 AND deleted_at IS NULL`,
+			identity.EmailLogin).Scan(&userID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, false, fmt.Errorf("failed to look up user by email: %w", err)
+		}
+	}
+
+	if userID == 0 {
+		if userID, err = createExternalUser(ctx, tx, identity); err != nil {
+			return 0, false, err
+		}
+		created = true
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())`,
+		userID, identity.Provider, identity.Subject, nullIfEmpty(identity.Email))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	return userID, created, tx.Commit(ctx)
+}
+
+// createExternalUser creates an account without a usable password, which
+// can later be set through the password reset flow.
+func createExternalUser(ctx context.Context, tx pgx.Tx, identity ExternalIdentity) (int, error) {
+	for _, login := range identity.NewLogins {
+		var userID int
+		err := tx.QueryRow(ctx,
+			`INSERT INTO users (login, login_normalized, password) VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+			RETURNING id`,
+			login.Login, login.LoginNormalized, NoPassword).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to create user: %w", err)
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO balance (user_id, current, withdrawn) VALUES ($1, 0, 0) ON CONFLICT (user_id) DO NOTHING`,
+			userID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to init balance: %w", err)
+		}
+
+		return userID, nil
+	}
+
+	return 0, ErrConflict
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -20,12 +20,24 @@ import (
 var ErrConflict = errors.New("conflict: duplicate entry")
 var ErrNotFound = errors.New("obj not found")
 var ErrInsufficientFunds = errors.New("insufficient funds")
+var ErrNotCancellable = errors.New("order cannot be cancelled")
+var ErrWithdrawalLimit = errors.New("withdrawal limit exceeded")
+
+const (
+	StatusSourceUpload = "upload"
+	StatusSourceWorker = "worker"
+	StatusSourceAdmin  = "admin"
+	StatusSourceUser   = "user"
+)
+
+const OrderStatusCancelled = "CANCELLED"
 
 type Order struct {
 	Number     string
 	Status     string
 	Accrual    float64
 	UploadedAt time.Time
+	Metadata   models.OrderMetadata
 }
 
 type Balance struct {
@@ -39,6 +51,18 @@ type Withdrawal struct {
 	ProcessedAt time.Time
 }
 
+type SavedOrder struct {
+	Number   string
+	UserID   int
+	Inserted bool
+}
+
+type OrderStatusChange struct {
+	Status    string
+	Source    string
+	ChangedAt time.Time
+}
+
 type OrderToUpdate struct {
 	ID     int
 	Number string
@@ -131,10 +155,10 @@ func (d *DBStorage) SaveUser(user models.UserDB) (int, error) {
 	var userID int
 
 	err := d.pool.QueryRow(ctx,
-		`INSERT INTO users (login, password)
-         VALUES ($1, $2)
+		`INSERT INTO users (login, login_normalized, password)
+         VALUES ($1, $2, $3)
 		 RETURNING id`,
-		user.Login, user.Password).Scan(&userID)
+		user.Login, user.LoginNormalized, user.Password).Scan(&userID)
 
 	if err != nil {
 		var pgErr *pgconn.PgError
@@ -147,35 +171,98 @@ func (d *DBStorage) SaveUser(user models.UserDB) (int, error) {
 	return userID, nil
 }
 
-func (d *DBStorage) GetUser(user models.UserDB) (int, error) {
+// GetUserByLogin looks the user up case-insensitively via the normalized
+// login. An exact match wins so that accounts whose logins clashed before
+// normalization was introduced remain reachable. Deleted accounts are not
+// found.
+func (d *DBStorage) GetUserByLogin(login, normalized string) (models.UserCredentials, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var userID int
+	var user models.UserCredentials
 
-	err := d.pool.QueryRow(ctx, `SELECT id FROM users WHERE login = $1 and password = $2`, user.Login, user.Password).Scan(&userID)
+	err := d.pool.QueryRow(ctx,
+		`SELECT id, password FROM users
+		WHERE (login = $1 OR login_normalized = $2) AND deleted_at IS NULL
+		ORDER BY login = $1 DESC LIMIT 1`,
+		login, normalized).Scan(&user.ID, &user.PasswordHash)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return 0, ErrNotFound
+			return models.UserCredentials{}, ErrNotFound
 		}
-		return 0, fmt.Errorf("failed to get user: %w", err)
+		return models.UserCredentials{}, fmt.Errorf("failed to get user: %w", err)
 	}
-	return userID, nil
+	return user, nil
+}
+
+func (d *DBStorage) GetUserCredentials(userID int) (models.UserCredentials, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user models.UserCredentials
+
+	err := d.pool.QueryRow(ctx, `SELECT id, password FROM users WHERE id = $1`, userID).Scan(&user.ID, &user.PasswordHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.UserCredentials{}, ErrNotFound
+		}
+		return models.UserCredentials{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// UpgradePasswordHash replaces currentHash with newHash. It does nothing if
+// the password was changed or reset since currentHash was read, so a slow
+// rehash after login cannot bring an old password back.
+func (d *DBStorage) UpgradePasswordHash(userID int, currentHash, newHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := d.pool.Exec(ctx, `UPDATE users SET password = $1 WHERE id = $2 AND password = $3`,
+		newHash, userID, currentHash)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade password hash: %w", err)
+	}
+	return nil
 }
 
-func (d *DBStorage) SaveOrder(order string, userID int) (int, error) {
+func (d *DBStorage) SaveOrder(order string, userID int, meta *models.OrderMetadata) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var existingUserID int
-	err := d.pool.QueryRow(ctx, `SELECT user_id FROM orders WHERE number = $1`, order).Scan(&existingUserID)
+	err := d.pool.QueryRow(ctx, `SELECT user_id FROM orders WHERE number = $1 AND status <> 'CANCELLED'`, order).Scan(&existingUserID)
 	if err == nil {
 		return existingUserID, ErrConflict
 	} else if !errors.Is(err, sql.ErrNoRows) {
 		return 0, fmt.Errorf("failed to check order existence: %w", err)
 	}
 
-	_, err = d.pool.Exec(ctx, `INSERT INTO orders (number, user_id) VALUES ($1, $2)`, order, userID)
+	var storeID, amount, purchasedAt, items any
+	if meta != nil {
+		if meta.StoreID != "" {
+			storeID = meta.StoreID
+		}
+		if meta.Amount != nil {
+			amount = *meta.Amount
+		}
+		if meta.PurchasedAt != nil {
+			purchasedAt = *meta.PurchasedAt
+		}
+		if len(meta.Items) > 0 {
+			items = meta.Items
+		}
+	}
+
+	_, err = d.pool.Exec(ctx,
+		`WITH inserted AS (
+			INSERT INTO orders (number, user_id, store_id, purchase_amount, purchased_at, items)
+			VALUES ($1, $2, $4, $5, $6, $7)
+			RETURNING id, status
+		)
+		INSERT INTO order_status_history (order_id, status, source)
+		SELECT id, status, $3 FROM inserted`,
+		order, userID, StatusSourceUpload, storeID, amount, purchasedAt, items)
 	if err != nil {
 		return 0, fmt.Errorf("failed to save order number: %w", err)
 	}
@@ -183,12 +270,103 @@ func (d *DBStorage) SaveOrder(order string, userID int) (int, error) {
 	return userID, nil
 }
 
+func (d *DBStorage) SaveOrders(orders []string, userID int) ([]SavedOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`WITH input AS (
+			SELECT DISTINCT unnest($1::varchar[]) AS number
+		), inserted AS (
+			INSERT INTO orders (number, user_id)
+			SELECT number, $2 FROM input
+			ON CONFLICT (number) WHERE status <> 'CANCELLED' DO NOTHING
+			RETURNING id, number, user_id, status
+		), history AS (
+			INSERT INTO order_status_history (order_id, status, source)
+			SELECT id, status, $3 FROM inserted
+		)
+		SELECT i.number, COALESCE(ins.user_id, o.user_id, 0), ins.number IS NOT NULL
+		FROM input i
+		LEFT JOIN inserted ins ON ins.number = i.number
+		LEFT JOIN orders o ON o.number = i.number AND o.status <> 'CANCELLED'`,
+		orders, userID, StatusSourceUpload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save orders: %w", err)
+	}
+	defer rows.Close()
+
+	var saved []SavedOrder
+	for rows.Next() {
+		var order SavedOrder
+		if err := rows.Scan(&order.Number, &order.UserID, &order.Inserted); err != nil {
+			return nil, fmt.Errorf("data scan error: %w", err)
+		}
+		saved = append(saved, order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows processing error: %w", err)
+	}
+	rows.Close()
+
+	// A number inserted by a concurrent upload conflicts with ours but is
+	// not visible to the statement's snapshot, so its owner comes back as
+	// zero. A new statement sees the committed row.
+	var unresolved []string
+	for _, order := range saved {
+		if order.UserID == 0 {
+			unresolved = append(unresolved, order.Number)
+		}
+	}
+	if len(unresolved) == 0 {
+		return saved, nil
+	}
+
+	owners := make(map[string]int, len(unresolved))
+	ownerRows, err := d.pool.Query(ctx,
+		`SELECT number, user_id FROM orders WHERE number = ANY($1) AND status <> 'CANCELLED'`,
+		unresolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up order owners: %w", err)
+	}
+	defer ownerRows.Close()
+
+	for ownerRows.Next() {
+		var number string
+		var ownerID int
+		if err := ownerRows.Scan(&number, &ownerID); err != nil {
+			return nil, fmt.Errorf("data scan error: %w", err)
+		}
+		owners[number] = ownerID
+	}
+	if err = ownerRows.Err(); err != nil {
+		return nil, fmt.Errorf("rows processing error: %w", err)
+	}
+
+	for i, order := range saved {
+		if order.UserID != 0 {
+			continue
+		}
+		ownerID, ok := owners[order.Number]
+		if !ok {
+			// The concurrent insert was rolled back; the upload can be
+			// retried.
+			return nil, fmt.Errorf("order %s was changed concurrently, retry the upload", order.Number)
+		}
+		saved[i].UserID = ownerID
+	}
+
+	return saved, nil
+}
+
 func (d *DBStorage) GetOrders(userID int) ([]Order, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	rows, err := d.pool.Query(ctx,
-		`SELECT number, status, accrual, uploaded_at FROM orders WHERE user_id = $1 ORDER BY uploaded_at DESC`,
+		`SELECT number, status, accrual, uploaded_at, COALESCE(store_id, ''), purchase_amount, purchased_at, items
+		FROM orders WHERE user_id = $1 ORDER BY uploaded_at DESC`,
 		userID)
 
 	if err != nil {
@@ -201,7 +379,9 @@ func (d *DBStorage) GetOrders(userID int) ([]Order, error) {
 	for rows.Next() {
 		var order Order
 
-		if err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt); err != nil {
+		err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt,
+			&order.Metadata.StoreID, &order.Metadata.Amount, &order.Metadata.PurchasedAt, &order.Metadata.Items)
+		if err != nil {
 			return nil, fmt.Errorf("data scan error: %w", err)
 		}
 		orders = append(orders, order)
@@ -233,7 +413,30 @@ func (d *DBStorage) GetBalance(userID int) (Balance, error) {
 	return balance, nil
 }
 
-func (d *DBStorage) SaveWithdrawal(userID int, order string, sum float64) error {
+// WithdrawalLimit caps the total a user withdraws within Window, the new
+// withdrawal included. A zero Sum means no cap.
+type WithdrawalLimit struct {
+	Sum    float64
+	Window time.Duration
+}
+
+// GetWithdrawnSince returns the total the user withdrew within window.
+func (d *DBStorage) GetWithdrawnSince(userID int, window time.Duration) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var withdrawn float64
+	err := d.pool.QueryRow(ctx,
+		`SELECT COALESCE(SUM(sum), 0)::float8 FROM withdrawals
+		WHERE user_id = $1 AND processed_at > NOW() - $2 * INTERVAL '1 second'`,
+		userID, window.Seconds()).Scan(&withdrawn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum withdrawals: %w", err)
+	}
+	return withdrawn, nil
+}
+
+func (d *DBStorage) SaveWithdrawal(userID int, order string, sum float64, limit WithdrawalLimit) error {
     ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
     defer cancel()
 
@@ -260,15 +463,32 @@ func (d *DBStorage) SaveWithdrawal(userID int, order string, sum float64) error
         return ErrInsufficientFunds
     }
 
-    commandTag, err := tx.Exec(ctx,
-        `UPDATE balance SET current = current - $1, withdrawn = withdrawn + $1 WHERE user_id = $2`,
-        sum, userID)
-    if err != nil {
-        return err
+    // The balance row lock serialises the user's withdrawals, so the sum
+    // below cannot change before this one is inserted.
+    if limit.Sum > 0 {
+        var withdrawn float64
+        err = tx.QueryRow(ctx,
+            `SELECT COALESCE(SUM(sum), 0)::float8 FROM withdrawals
+            WHERE user_id = $1 AND processed_at > NOW() - $2 * INTERVAL '1 second'`,
+            userID, limit.Window.Seconds(),
+        ).Scan(&withdrawn)
+        if err != nil {
+            return err
+        }
+        if withdrawn+sum > limit.Sum {
+            return ErrWithdrawalLimit
+        }
     }
 
-    if commandTag.RowsAffected() == 0 {
-        return ErrNotFound
+    var event BalanceEvent
+    err = tx.QueryRow(ctx,
+        `UPDATE balance SET current = current - $1, withdrawn = withdrawn + $1 WHERE user_id = $2 RETURNING current, withdrawn`,
+        sum, userID).Scan(&event.Current, &event.Withdrawn)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return ErrNotFound
+        }
+        return err
     }
 
     _, err = tx.Exec(ctx,
@@ -278,6 +498,10 @@ func (d *DBStorage) SaveWithdrawal(userID int, order string, sum float64) error
         return err
     }
 
+    if err := insertUserEvent(ctx, tx, userID, EventBalance, event); err != nil {
+        return err
+    }
+
     return tx.Commit(ctx)
 }
 
@@ -315,7 +539,8 @@ func (d *DBStorage) GetOrder(orderNum string) (Order, error) {
 	var order Order
 
 	err := d.pool.QueryRow(ctx,
-		`SELECT number, status, accrual, uploaded_at FROM orders WHERE number = $1`,
+		`SELECT number, status, accrual, uploaded_at FROM orders WHERE number = $1
+		ORDER BY status = 'CANCELLED', uploaded_at DESC LIMIT 1`,
 		orderNum).Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
 
 	if err != nil {
@@ -327,6 +552,26 @@ func (d *DBStorage) GetOrder(orderNum string) (Order, error) {
 	return order, nil
 }
 
+func (d *DBStorage) GetUserOrder(orderNum string, userID int) (Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order Order
+
+	err := d.pool.QueryRow(ctx,
+		`SELECT number, status, accrual, uploaded_at FROM orders WHERE number = $1 AND user_id = $2
+		ORDER BY status = 'CANCELLED', uploaded_at DESC LIMIT 1`,
+		orderNum, userID).Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Order{}, ErrNotFound
+		}
+		return Order{}, err
+	}
+	return order, nil
+}
+
 func (d *DBStorage) InitBalance(userID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -359,22 +604,165 @@ func (d *DBStorage) GetOrdersToUpdate() ([]OrderToUpdate, error) {
 	return orders, rows.Err()
 }
 
-func (d *DBStorage) UpdateOrderStatus(orderID int, status string, accrual float64) error {
+func (d *DBStorage) UpdateOrderStatus(orderID int, status string, accrual float64, source string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := d.pool.Exec(ctx,
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var number, currentStatus string
+	var userID int
+	err = tx.QueryRow(ctx,
+		`SELECT number, user_id, status FROM orders WHERE id = $1 FOR UPDATE`,
+		orderID).Scan(&number, &userID, &currentStatus)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNotFound
+		}
+		return false, err
+	}
+
+	if currentStatus == OrderStatusCancelled {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx,
 		`UPDATE orders SET status = $1, accrual = $2 WHERE id = $3`,
 		status, accrual, orderID)
-	return err
+	if err != nil {
+		return false, err
+	}
+
+	changed := currentStatus != status
+	if changed {
+		if err := insertStatusChange(ctx, tx, orderID, status, source); err != nil {
+			return false, err
+		}
+
+		event := OrderStatusEvent{Number: number, Status: status, Accrual: accrual}
+		if err := insertUserEvent(ctx, tx, userID, EventOrderStatus, event); err != nil {
+			return false, err
+		}
+
+		// The accrual is credited in the same transaction as the status
+		// change: once PROCESSED is committed the order is never polled
+		// again, so a separate credit could be lost.
+		if status == "PROCESSED" && accrual > 0 {
+			if err := adjustBalance(ctx, tx, userID, accrual); err != nil {
+				return false, fmt.Errorf("failed to credit accrual: %w", err)
+			}
+		}
+	}
+
+	return changed, tx.Commit(ctx)
 }
 
-func (d *DBStorage) UpdateBalance(userID int, amount float64) error {
+func (d *DBStorage) CancelOrder(orderNum string, userID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := d.pool.Exec(ctx,
-		`UPDATE balance SET current = current + $1 WHERE user_id = $2`,
-		amount, userID)
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var orderID int
+	var accrual float64
+	err = tx.QueryRow(ctx,
+		`UPDATE orders SET status = 'CANCELLED'
+		WHERE number = $1 AND user_id = $2 AND status = 'NEW'
+		RETURNING id, accrual`,
+		orderNum, userID).Scan(&orderID, &accrual)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM orders WHERE number = $1 AND user_id = $2 AND status <> 'CANCELLED')`,
+			orderNum, userID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrNotCancellable
+		}
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := insertStatusChange(ctx, tx, orderID, OrderStatusCancelled, StatusSourceUser); err != nil {
+		return err
+	}
+
+	event := OrderStatusEvent{Number: orderNum, Status: OrderStatusCancelled, Accrual: accrual}
+	if err := insertUserEvent(ctx, tx, userID, EventOrderStatus, event); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (d *DBStorage) GetOrderHistory(orderNum string, userID int) ([]OrderStatusChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT h.status, h.source, h.changed_at
+		FROM order_status_history h
+		WHERE h.order_id = (
+			SELECT id FROM orders WHERE number = $1 AND user_id = $2
+			ORDER BY status = 'CANCELLED', uploaded_at DESC LIMIT 1
+		)
+		ORDER BY h.changed_at, h.id`,
+		orderNum, userID)
+	if err != nil {
+		return nil, fmt.Errorf("database query error: %w", err)
+	}
+	defer rows.Close()
+
+	var history []OrderStatusChange
+	for rows.Next() {
+		var change OrderStatusChange
+		if err := rows.Scan(&change.Status, &change.Source, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("data scan error: %w", err)
+		}
+		history = append(history, change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows processing error: %w", err)
+	}
+
+	if len(history) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return history, nil
+}
+
+func adjustBalance(ctx context.Context, tx pgx.Tx, userID int, amount float64) error {
+	var event BalanceEvent
+	err := tx.QueryRow(ctx,
+		`UPDATE balance SET current = current + $1 WHERE user_id = $2 RETURNING current, withdrawn`,
+		amount, userID).Scan(&event.Current, &event.Withdrawn)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return insertUserEvent(ctx, tx, userID, EventBalance, event)
+}
+
+func insertStatusChange(ctx context.Context, tx pgx.Tx, orderID int, status, source string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO order_status_history (order_id, status, source) VALUES ($1, $2, $3)`,
+		orderID, status, source)
 	return err
 }
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// rateLimitTimeout is kept well below the usual query timeout because the
+// limiter sits in front of every limited request.
+const rateLimitTimeout = time.Second
+
+// TakeRateLimit runs one step of the generic cell rate algorithm for key:
+// a request is admitted when pushing the key's theoretical arrival time
+// forward by interval keeps it within tolerance of now. It reports whether
+// the request was admitted and how far the arrival time is ahead of now,
+// which is negative for a key that has been idle.
+func (d *DBStorage) TakeRateLimit(key string, interval, tolerance time.Duration) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitTimeout)
+	defer cancel()
+
+	var allowed bool
+	var ahead float64
+	err := d.pool.QueryRow(ctx,
+		`WITH updated AS (
+			INSERT INTO rate_limits (key, tat) VALUES ($1, NOW() + $2 * INTERVAL '1 second')
+			ON CONFLICT (key) DO UPDATE
+				SET tat = GREATEST(rate_limits.tat, NOW()) + $2 * INTERVAL '1 second'
+				WHERE GREATEST(rate_limits.tat, NOW()) + $2 * INTERVAL '1 second' <= NOW() + $3 * INTERVAL '1 second'
+			RETURNING tat
+		)
+		SELECT EXISTS (SELECT 1 FROM updated),
+			EXTRACT(EPOCH FROM COALESCE(
+				(SELECT tat FROM updated),
+				(SELECT tat FROM rate_limits WHERE key = $1),
+				NOW()) - NOW())::float8`,
+		key, interval.Seconds(), tolerance.Seconds()).Scan(&allowed, &ahead)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take rate limit: %w", err)
+	}
+
+	return allowed, time.Duration(ahead * float64(time.Second)), nil
+}
+
+// DeleteExpiredRateLimits removes keys whose arrival time has passed; such
+// keys behave exactly like keys that were never seen.
+func (d *DBStorage) DeleteExpiredRateLimits() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := d.pool.Exec(ctx, `DELETE FROM rate_limits WHERE tat < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired rate limits: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
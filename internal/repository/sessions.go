@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written for a
+// session that keeps making requests.
+const sessionTouchInterval = time.Minute
+
+type Session struct {
+	ID         string
+	UserID     int
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// GetSessions returns the user's sessions that have not ended and can still
+// be refreshed.
+func (d *DBStorage) GetSessions(userID int) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT s.id, s.user_id, COALESCE(s.user_agent, ''), COALESCE(s.ip, ''), s.created_at, s.last_seen_at
+		FROM sessions s
+		WHERE s.user_id = $1 AND s.ended_at IS NULL
+			AND EXISTS (
+				SELECT 1 FROM refresh_tokens t
+				WHERE t.family_id = s.id AND t.revoked_at IS NULL AND t.used_at IS NULL AND t.expires_at > NOW()
+			)
+		ORDER BY s.last_seen_at DESC`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP,
+			&session.CreatedAt, &session.LastSeenAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (d *DBStorage) EndSession(userID int, sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ended, err := endSession(ctx, tx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if !ended {
+		return ErrNotFound
+	}
+
+	return tx.Commit(ctx)
+}
+
+func endSession(ctx context.Context, tx pgx.Tx, userID int, sessionID string) (bool, error) {
+	tag, err := tx.Exec(ctx,
+		`UPDATE sessions SET ended_at = NOW() WHERE id = $1 AND user_id = $2 AND ended_at IS NULL`,
+		sessionID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to end session: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL`,
+		userID, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,361 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mdflamingo/Gofermart/internal/models"
+)
+
+var ErrTokenReused = errors.New("refresh token reuse detected")
+
+type RefreshToken struct {
+	UserID       int
+	FamilyID     string
+	TokenVersion int
+	Role         string
+}
+
+// SaveRefreshToken starts a new session: the token family ID doubles as the
+// session ID.
+func (d *DBStorage) SaveRefreshToken(userID int, familyID, tokenHash string, expiresAt time.Time, client models.ClientInfo) (RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < NOW()`,
+		userID)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO sessions (id, user_id, user_agent, ip) VALUES ($1, $2, $3, $4)`,
+		familyID, userID, client.UserAgent, client.IP)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("failed to save session: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`,
+		userID, familyID, tokenHash, expiresAt)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	token := RefreshToken{UserID: userID, FamilyID: familyID}
+	err = tx.QueryRow(ctx,
+		`SELECT token_version, role FROM users WHERE id = $1`,
+		userID).Scan(&token.TokenVersion, &token.Role)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return token, tx.Commit(ctx)
+}
+
+func (d *DBStorage) RotateRefreshToken(oldHash, newHash string, expiresAt time.Time, client models.ClientInfo) (RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	var token RefreshToken
+	var tokenExpiresAt time.Time
+	var usedAt, revokedAt *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT id, user_id, family_id, expires_at, used_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`,
+		oldHash).Scan(&id, &token.UserID, &token.FamilyID, &tokenExpiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, ErrNotFound
+		}
+		return RefreshToken{}, err
+	}
+
+	if revokedAt != nil || time.Now().After(tokenExpiresAt) {
+		return RefreshToken{}, ErrNotFound
+	}
+
+	if usedAt != nil {
+		if _, err := endSession(ctx, tx, token.UserID, token.FamilyID); err != nil {
+			return RefreshToken{}, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return RefreshToken{}, err
+		}
+		return token, ErrTokenReused
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1`, id)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE sessions SET last_seen_at = NOW(), user_agent = $2, ip = $3 WHERE id = $1`,
+		token.FamilyID, client.UserAgent, client.IP)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`,
+		token.UserID, token.FamilyID, newHash, expiresAt)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	err = tx.QueryRow(ctx,
+		`SELECT token_version, role FROM users WHERE id = $1`,
+		token.UserID).Scan(&token.TokenVersion, &token.Role)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return token, tx.Commit(ctx)
+}
+
+func (d *DBStorage) RevokeTokenFamily(userID int, familyID, jti string, accessExpiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if familyID != "" {
+		if _, err := endSession(ctx, tx, userID, familyID); err != nil {
+			return err
+		}
+	}
+
+	if jti != "" {
+		_, err = tx.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`)
+		if err != nil {
+			return fmt.Errorf("failed to delete expired revocations: %w", err)
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`,
+			jti, accessExpiresAt)
+		if err != nil {
+			return fmt.Errorf("failed to revoke access token: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (d *DBStorage) RevokeAllTokens(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := revokeAllTokens(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func revokeAllTokens(ctx context.Context, tx pgx.Tx, userID int) error {
+	_, err := tx.Exec(ctx, `UPDATE users SET token_version = token_version + 1 WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to bump token version: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE sessions SET ended_at = NOW() WHERE user_id = $1 AND ended_at IS NULL`,
+		userID)
+	if err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DBStorage) ChangePassword(userID int, passwordHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `UPDATE users SET password = $1 WHERE id = $2`, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := revokeAllTokens(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (d *DBStorage) SavePasswordResetToken(userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1 AND (used_at IS NULL OR expires_at < NOW())`,
+		userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete previous reset tokens: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userID, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save reset token: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetPasswordResetLogin returns the login of the user an unused, unexpired
+// reset token belongs to without redeeming it.
+func (d *DBStorage) GetPasswordResetLogin(tokenHash string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var login string
+	err := d.pool.QueryRow(ctx,
+		`SELECT u.login FROM password_reset_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > NOW() AND u.deleted_at IS NULL`,
+		tokenHash).Scan(&login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("failed to get reset token: %w", err)
+	}
+	return login, nil
+}
+
+func (d *DBStorage) ResetPassword(tokenHash, passwordHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx,
+		`UPDATE password_reset_tokens t SET used_at = NOW()
+		FROM users u
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > NOW()
+			AND u.id = t.user_id AND u.deleted_at IS NULL
+		RETURNING t.user_id`,
+		tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET password = $1 WHERE id = $2`, passwordHash, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := revokeAllTokens(ctx, tx, userID); err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit(ctx)
+}
+
+// GetSessionCreatedAt returns when an active session was started, which is
+// when the user last proved who they are on it.
+func (d *DBStorage) GetSessionCreatedAt(userID int, sessionID string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var createdAt time.Time
+	err := d.pool.QueryRow(ctx,
+		`SELECT created_at FROM sessions WHERE id = $1 AND user_id = $2 AND ended_at IS NULL`,
+		sessionID, userID).Scan(&createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, ErrNotFound
+		}
+		return time.Time{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	return createdAt, nil
+}
+
+// CheckAccessToken reports whether an access token is still valid: the
+// account was not deleted, the token version matches, the token was not
+// revoked and its session exists and has not ended. As a side effect it
+// refreshes the session's last_seen_at, at most once per
+// sessionTouchInterval.
+func (d *DBStorage) CheckAccessToken(userID, tokenVersion int, jti, sessionID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var valid bool
+	err := d.pool.QueryRow(ctx,
+		`WITH touched AS (
+			UPDATE sessions SET last_seen_at = NOW()
+			WHERE id = $4 AND user_id = $1 AND ended_at IS NULL
+				AND last_seen_at < NOW() - $5 * INTERVAL '1 second'
+		)
+		SELECT token_version = $2
+			AND NOT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $3)
+			AND EXISTS (SELECT 1 FROM sessions WHERE id = $4 AND user_id = $1 AND ended_at IS NULL)
+		FROM users WHERE id = $1 AND deleted_at IS NULL`,
+		userID, tokenVersion, jti, sessionID, sessionTouchInterval.Seconds()).Scan(&valid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return valid, nil
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type UserTOTP struct {
+	Secret       string
+	Enabled      bool
+	LastUsedStep *int64
+}
+
+func (d *DBStorage) GetUserLogin(userID int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var login string
+	err := d.pool.QueryRow(ctx, `SELECT login FROM users WHERE id = $1`, userID).Scan(&login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("failed to get user login: %w", err)
+	}
+	return login, nil
+}
+
+func (d *DBStorage) GetUserTOTP(userID int) (UserTOTP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var totp UserTOTP
+	err := d.pool.QueryRow(ctx,
+		`SELECT secret, enabled, last_used_step FROM user_totp WHERE user_id = $1`,
+		userID).Scan(&totp.Secret, &totp.Enabled, &totp.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserTOTP{}, ErrNotFound
+		}
+		return UserTOTP{}, fmt.Errorf("failed to get TOTP settings: %w", err)
+	}
+	return totp, nil
+}
+
+// SaveTOTPSecret stores a pending secret, replacing any previous unconfirmed
+// enrolment. It returns ErrConflict when 2FA is already enabled.
+func (d *DBStorage) SaveTOTPSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := d.pool.Exec(ctx,
+		`INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET secret = EXCLUDED.secret, last_used_step = NULL, created_at = NOW()
+			WHERE NOT user_totp.enabled`,
+		userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to save TOTP secret: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+func (d *DBStorage) EnableTOTP(userID int, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE user_totp SET enabled = TRUE, enabled_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND NOT enabled`,
+		userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConflict
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO totp_recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])`,
+		userID, recoveryCodeHashes)
+	if err != nil {
+		return fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep records step as used and reports false when the same or a
+// later step has already been accepted, which rejects replayed codes.
+func (d *DBStorage) UseTOTPStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := d.pool.Exec(ctx,
+		`UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND enabled AND (last_used_step IS NULL OR last_used_step < $2)`,
+		userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to use TOTP step: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (d *DBStorage) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := d.pool.Exec(ctx,
+		`UPDATE totp_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (d *DBStorage) DisableTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete TOTP settings: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
+)
+
+// reauthWindow is how recently a user without a password must have signed
+// in to delete their account.
+const reauthWindow = 5 * time.Minute
+
+var ErrReauthenticationRequired = errors.New("recent sign-in required")
+
+// Lifetime points needed to reach each tier above bronze.
+const (
+	silverTierPoints   = 1000
+	goldTierPoints     = 5000
+	platinumTierPoints = 20000
+)
+
+type AccountService struct {
+	repo      *repository.DBStorage
+	hasher    *PasswordHasher
+	orders    *OrderService
+	balance   *BalanceService
+	twoFactor *TwoFactorService
+	guard     *LoginGuard
+}
+
+func NewAccountService(repo *repository.DBStorage, hasher *PasswordHasher, orders *OrderService, balance *BalanceService, twoFactor *TwoFactorService, guard *LoginGuard) *AccountService {
+	return &AccountService{
+		repo:      repo,
+		hasher:    hasher,
+		orders:    orders,
+		balance:   balance,
+		twoFactor: twoFactor,
+		guard:     guard,
+	}
+}
+
+func (s *AccountService) GetProfile(userID int) (*models.ProfileResponse, error) {
+	profile, err := s.repo.GetUserProfile(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	twoFactorEnabled, err := s.twoFactor.Enabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ProfileResponse{
+		Login:            profile.Login,
+		Role:             profile.Role,
+		CreatedAt:        profile.CreatedAt,
+		LastLogin:        profile.LastLoginAt,
+		Tier:             tierFor(profile.LifetimePoints),
+		LifetimePoints:   profile.LifetimePoints,
+		TwoFactorEnabled: twoFactorEnabled,
+	}, nil
+}
+
+func (s *AccountService) Export(userID int) (*models.AccountExport, error) {
+	profile, err := s.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := s.orders.GetUserOrders(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawals, err := s.balance.GetWithdrawals(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.repo.GetBalanceHistory(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &models.AccountExport{
+		ExportedAt:     time.Now(),
+		Profile:        *profile,
+		Orders:         orders,
+		Withdrawals:    withdrawals,
+		BalanceHistory: make([]models.LedgerEntry, 0, len(history)),
+	}
+	if export.Orders == nil {
+		export.Orders = []models.OrdersResponse{}
+	}
+	for _, entry := range history {
+		export.BalanceHistory = append(export.BalanceHistory, models.LedgerEntry{
+			Type:      entry.Type,
+			Reference: entry.Reference,
+			Amount:    entry.Amount,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+
+	return export, nil
+}
+
+// Delete anonymises the account after re-checking the password and, when
+// enabled, a two-factor code. Accounts created through an identity provider
+// have no password; for them the session must instead have been started
+// within reauthWindow. Wrong passwords count towards the same lockout as
+// failed logins.
+func (s *AccountService) Delete(userID int, sessionID string, req models.DeleteAccountRequest, clientIP string) error {
+	credentials, err := s.repo.GetUserCredentials(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	login, err := s.repo.GetUserLogin(userID)
+	if err != nil {
+		return err
+	}
+
+	if credentials.PasswordHash == repository.NoPassword {
+		if err := s.checkRecentSignIn(userID, sessionID); err != nil {
+			return err
+		}
+	} else if err := s.checkPassword(login, req.Password, credentials.PasswordHash, clientIP); err != nil {
+		return err
+	}
+
+	twoFactorEnabled, err := s.twoFactor.Enabled(userID)
+	if err != nil {
+		return err
+	}
+	if twoFactorEnabled {
+		if err := s.twoFactor.VerifyCode(userID, req.TOTPCode, clientIP); err != nil {
+			return err
+		}
+	}
+
+	attemptKeys := []string{loginKey(login), secondFactorKey(userID)}
+	if err := s.repo.DeleteAccount(userID, attemptKeys); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	logger.Log.Info("account deleted", zap.Int("user_id", userID))
+	return nil
+}
+
+func (s *AccountService) checkPassword(login, password, passwordHash, clientIP string) error {
+	attempt, err := s.guard.Begin(login, clientIP)
+	if err != nil {
+		return err
+	}
+
+	ok, _, err := s.hasher.Verify(password, passwordHash)
+	if err != nil {
+		attempt.Release()
+		return err
+	}
+	if !ok {
+		attempt.Fail()
+		return ErrWrongPassword
+	}
+
+	attempt.Succeed()
+	return nil
+}
+
+func (s *AccountService) checkRecentSignIn(userID int, sessionID string) error {
+	if sessionID == "" {
+		return ErrReauthenticationRequired
+	}
+
+	createdAt, err := s.repo.GetSessionCreatedAt(userID, sessionID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrReauthenticationRequired
+		}
+		return err
+	}
+	if time.Since(createdAt) > reauthWindow {
+		return ErrReauthenticationRequired
+	}
+	return nil
+}
+
+func tierFor(points float64) string {
+	switch {
+	case points >= platinumTierPoints:
+		return models.TierPlatinum
+	case points >= goldTierPoints:
+		return models.TierGold
+	case points >= silverTierPoints:
+		return models.TierSilver
+	default:
+		return models.TierBronze
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUserNotFound         = errors.New("user not found")
+	ErrInvalidRole          = errors.New("invalid role")
+	ErrCannotChangeOwnRole  = errors.New("cannot change own role")
+	ErrInvalidAdjustment    = errors.New("adjustment requires a non-zero amount and a reason")
+	ErrInvalidSearchRequest = errors.New("search query is required")
+)
+
+const (
+	defaultUserSearchLimit = 20
+	maxUserSearchLimit     = 100
+	maxAdjustmentReasonLen = 1000
+)
+
+type AdminService struct {
+	repo *repository.DBStorage
+}
+
+func NewAdminService(repo *repository.DBStorage) *AdminService {
+	return &AdminService{repo: repo}
+}
+
+func (s *AdminService) SearchUsers(query string, limit int) ([]models.AdminUserResponse, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrInvalidSearchRequest
+	}
+	if limit <= 0 {
+		limit = defaultUserSearchLimit
+	}
+	limit = min(limit, maxUserSearchLimit)
+
+	users, err := s.repo.SearchUsers(query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.AdminUserResponse, 0, len(users))
+	for _, user := range users {
+		responses = append(responses, newAdminUserResponse(user))
+	}
+	return responses, nil
+}
+
+func (s *AdminService) GetUser(userID int) (*models.AdminUserResponse, error) {
+	user, err := s.repo.GetAdminUser(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	resp := newAdminUserResponse(user)
+	return &resp, nil
+}
+
+func (s *AdminService) SetRole(actorID, userID int, role string) error {
+	if !slices.Contains(models.Roles, role) {
+		return ErrInvalidRole
+	}
+	if actorID == userID {
+		return ErrCannotChangeOwnRole
+	}
+
+	if err := s.repo.SetUserRole(userID, role); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	logger.Log.Info("user role changed",
+		zap.Int("actor_id", actorID),
+		zap.Int("user_id", userID),
+		zap.String("role", role))
+	return nil
+}
+
+// BootstrapAdmin grants the admin role to login so that a fresh deployment
+// has someone able to assign roles through the API. Once any admin exists
+// it does nothing, so a demoted bootstrap login is not promoted again.
+func (s *AdminService) BootstrapAdmin(login string) error {
+	hasAdmin, err := s.repo.HasAdmin()
+	if err != nil {
+		return err
+	}
+	if hasAdmin {
+		logger.Log.Info("admin already exists, skipping bootstrap", zap.String("login", login))
+		return nil
+	}
+
+	userID, err := s.repo.GetUserIDByLogin(NormalizeLogin(login))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, login)
+		}
+		return err
+	}
+
+	user, err := s.repo.GetAdminUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.Role == models.RoleAdmin {
+		return nil
+	}
+
+	if err := s.repo.SetUserRole(userID, models.RoleAdmin); err != nil {
+		return err
+	}
+
+	logger.Log.Info("bootstrap admin granted", zap.String("login", login), zap.Int("user_id", userID))
+	return nil
+}
+
+func (s *AdminService) AdjustBalance(actorID, userID int, req models.BalanceAdjustmentRequest, actorIP string) (*models.BalanceAdjustmentResponse, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if req.Amount == 0 || math.IsNaN(req.Amount) || math.IsInf(req.Amount, 0) ||
+		reason == "" || len(reason) > maxAdjustmentReasonLen {
+		return nil, ErrInvalidAdjustment
+	}
+
+	adjustment, err := s.repo.AdjustBalance(repository.BalanceAdjustment{
+		UserID:  userID,
+		Amount:  req.Amount,
+		Reason:  reason,
+		Actor:   fmt.Sprintf("user:%d", actorID),
+		ActorIP: actorIP,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			return nil, ErrUserNotFound
+		case errors.Is(err, repository.ErrInsufficientFunds):
+			return nil, ErrInsufficientFunds
+		}
+		return nil, err
+	}
+
+	logger.Log.Info("balance adjusted",
+		zap.Int("actor_id", actorID),
+		zap.Int("user_id", userID),
+		zap.Float64("amount", req.Amount),
+		zap.String("reason", reason))
+
+	resp := newBalanceAdjustmentResponse(adjustment)
+	return &resp, nil
+}
+
+func (s *AdminService) GetBalanceAdjustments(userID int) ([]models.BalanceAdjustmentResponse, error) {
+	adjustments, err := s.repo.GetBalanceAdjustments(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.BalanceAdjustmentResponse, 0, len(adjustments))
+	for _, a := range adjustments {
+		responses = append(responses, newBalanceAdjustmentResponse(a))
+	}
+	return responses, nil
+}
+
+func newAdminUserResponse(user repository.AdminUser) models.AdminUserResponse {
+	return models.AdminUserResponse{
+		ID:    user.ID,
+		Login: user.Login,
+		Role:  user.Role,
+	}
+}
+
+func newBalanceAdjustmentResponse(a repository.BalanceAdjustment) models.BalanceAdjustmentResponse {
+	return models.BalanceAdjustmentResponse{
+		ID:        a.ID,
+		UserID:    a.UserID,
+		Amount:    a.Amount,
+		Reason:    a.Reason,
+		Actor:     a.Actor,
+		CreatedAt: a.CreatedAt,
+	}
+}
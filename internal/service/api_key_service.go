@@ -0,0 +1,153 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidAPIKey        = errors.New("invalid API key")
+	ErrAPIKeyNotFound       = errors.New("API key not found")
+	ErrInvalidAPIKeyRequest = errors.New("invalid API key request")
+)
+
+const (
+	apiKeyPrefix     = "gm"
+	maxAPIKeyNameLen = 100
+)
+
+type APIKeyService struct {
+	repo *repository.DBStorage
+}
+
+func NewAPIKeyService(repo *repository.DBStorage) *APIKeyService {
+	return &APIKeyService{repo: repo}
+}
+
+// Create issues a key of the form gm_<prefix>_<secret>. Only its hash is
+// stored, so the plain key is returned to the caller exactly once.
+func (s *APIKeyService) Create(userID int, req models.APIKeyRequest) (*models.APIKeyCreatedResponse, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxAPIKeyNameLen {
+		return nil, ErrInvalidAPIKeyRequest
+	}
+	if len(req.Scopes) == 0 {
+		return nil, ErrInvalidAPIKeyRequest
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) {
+			return nil, ErrInvalidAPIKeyRequest
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidAPIKeyRequest
+	}
+
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	plainKey := apiKeyPrefix + "_" + prefix + "_" + secret
+
+	key, err := s.repo.SaveAPIKey(repository.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	}, hashToken(plainKey))
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("API key created",
+		zap.Int("user_id", userID),
+		zap.Int64("key_id", key.ID),
+		zap.Strings("scopes", scopes))
+
+	return &models.APIKeyCreatedResponse{
+		APIKeyResponse: newAPIKeyResponse(key),
+		Key:            plainKey,
+	}, nil
+}
+
+func (s *APIKeyService) List(userID int) ([]models.APIKeyResponse, error) {
+	keys, err := s.repo.GetAPIKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		responses = append(responses, newAPIKeyResponse(key))
+	}
+	return responses, nil
+}
+
+func (s *APIKeyService) Revoke(userID int, keyID int64) error {
+	if err := s.repo.RevokeAPIKey(keyID, userID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+
+	logger.Log.Info("API key revoked", zap.Int("user_id", userID), zap.Int64("key_id", keyID))
+	return nil
+}
+
+func (s *APIKeyService) VerifyAPIKey(plainKey string) (*models.AccessClaims, error) {
+	if !strings.HasPrefix(plainKey, apiKeyPrefix+"_") {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.repo.GetActiveAPIKey(hashToken(plainKey))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	claims := &models.AccessClaims{
+		UserID:   key.UserID,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}
+	if key.ExpiresAt != nil {
+		claims.ExpiresAt = *key.ExpiresAt
+	}
+	return claims, nil
+}
+
+func newAPIKeyResponse(key repository.APIKey) models.APIKeyResponse {
+	return models.APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"time"
 
 	"github.com/mdflamingo/Gofermart/internal/models"
 	"github.com/mdflamingo/Gofermart/internal/repository"
@@ -13,11 +14,24 @@ var (
 )
 
 type BalanceService struct {
-	repo *repository.DBStorage
+	repo          *repository.DBStorage
+	validator     OrderNumberValidator
+	twoFactor     *TwoFactorService
+	totpThreshold float64
+	totpWindow    time.Duration
 }
 
-func NewBalanceService(repo *repository.DBStorage) *BalanceService {
-	return &BalanceService{repo: repo}
+// NewBalanceService creates the balance service. Users who enabled 2FA need
+// a two-factor code once their withdrawals within totpWindow would add up to
+// more than totpThreshold; a zero threshold disables the check.
+func NewBalanceService(repo *repository.DBStorage, validator OrderNumberValidator, twoFactor *TwoFactorService, totpThreshold float64, totpWindow time.Duration) *BalanceService {
+	return &BalanceService{
+		repo:          repo,
+		validator:     validator,
+		twoFactor:     twoFactor,
+		totpThreshold: totpThreshold,
+		totpWindow:    totpWindow,
+	}
 }
 
 func (s *BalanceService) GetBalance(userID int) (*models.BalanceResponse, error) {
@@ -35,9 +49,15 @@ func (s *BalanceService) GetBalance(userID int) (*models.BalanceResponse, error)
 	}, nil
 }
 
-func (s *BalanceService) Withdraw(userID int, orderNum string, sum float64) error {
-	if err := ValidateOrderNumber(orderNum); err != nil {
-		return ErrInvalidOrderNumber
+func (s *BalanceService) Withdraw(userID int, orderNum string, sum float64, totpCode, clientIP string) error {
+	orderNum, err := checkOrderNumber(s.validator, orderNum)
+	if err != nil {
+		return err
+	}
+
+	limit, err := s.checkSecondFactor(userID, sum, totpCode, clientIP)
+	if err != nil {
+		return err
 	}
 
 	balance, err := s.repo.GetBalance(userID)
@@ -52,10 +72,13 @@ func (s *BalanceService) Withdraw(userID int, orderNum string, sum float64) erro
 		return ErrInsufficientFunds
 	}
 
-	err = s.repo.SaveWithdrawal(userID, orderNum, sum)
+	err = s.repo.SaveWithdrawal(userID, orderNum, sum, limit)
 	if err != nil {
-		if errors.Is(err, repository.ErrInsufficientFunds) {
+		switch {
+		case errors.Is(err, repository.ErrInsufficientFunds):
 			return ErrInsufficientFunds
+		case errors.Is(err, repository.ErrWithdrawalLimit):
+			return ErrTOTPRequired
 		}
 		return err
 	}
@@ -63,6 +86,31 @@ func (s *BalanceService) Withdraw(userID int, orderNum string, sum float64) erro
 	return nil
 }
 
+// checkSecondFactor verifies the code when the withdrawals of the last
+// totpWindow, this one included, exceed totpThreshold. Otherwise it returns
+// the limit SaveWithdrawal has to enforce, so that withdrawals made in
+// parallel cannot add up past the threshold without a code.
+func (s *BalanceService) checkSecondFactor(userID int, sum float64, totpCode, clientIP string) (repository.WithdrawalLimit, error) {
+	if s.totpThreshold <= 0 {
+		return repository.WithdrawalLimit{}, nil
+	}
+
+	enabled, err := s.twoFactor.Enabled(userID)
+	if err != nil || !enabled {
+		return repository.WithdrawalLimit{}, err
+	}
+
+	withdrawn, err := s.repo.GetWithdrawnSince(userID, s.totpWindow)
+	if err != nil {
+		return repository.WithdrawalLimit{}, err
+	}
+	if withdrawn+sum <= s.totpThreshold {
+		return repository.WithdrawalLimit{Sum: s.totpThreshold, Window: s.totpWindow}, nil
+	}
+
+	return repository.WithdrawalLimit{}, s.twoFactor.VerifyCode(userID, totpCode, clientIP)
+}
+
 func (s *BalanceService) GetWithdrawals(userID int) ([]models.WithdrawnResponse, error) {
 	withdrawals, err := s.repo.GetWithdrawals(userID)
 	if err != nil {
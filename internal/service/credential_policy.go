@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mdflamingo/Gofermart/internal/models"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// ValidationError carries every rule a request violated so that clients
+// can show all problems at once.
+type ValidationError struct {
+	Fields []models.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		messages = append(messages, f.Field+": "+f.Message)
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+type CredentialPolicyConfig struct {
+	LoginMinLength     int
+	LoginMaxLength     int
+	LoginPattern       string
+	PasswordMinLength  int
+	PasswordMinClasses int
+	DenyListFile       string
+}
+
+type CredentialPolicy struct {
+	conf         CredentialPolicyConfig
+	loginPattern *regexp.Regexp
+	denyList     map[string]struct{}
+}
+
+// NewCredentialPolicy builds the policy from config. The embedded list of
+// common passwords is always denied; DenyListFile adds one password per
+// line on top of it.
+func NewCredentialPolicy(conf CredentialPolicyConfig) (*CredentialPolicy, error) {
+	p := &CredentialPolicy{conf: conf, denyList: make(map[string]struct{})}
+
+	if conf.LoginPattern != "" {
+		pattern, err := regexp.Compile(conf.LoginPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid login pattern: %w", err)
+		}
+		p.loginPattern = pattern
+	}
+
+	if err := p.addDenied(strings.NewReader(commonPasswords)); err != nil {
+		return nil, fmt.Errorf("failed to read built-in password deny list: %w", err)
+	}
+
+	if conf.DenyListFile != "" {
+		f, err := os.Open(conf.DenyListFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open password deny list: %w", err)
+		}
+		defer f.Close()
+
+		if err := p.addDenied(f); err != nil {
+			return nil, fmt.Errorf("failed to read password deny list: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *CredentialPolicy) addDenied(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			p.denyList[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// NormalizeLogin returns the form used for case-insensitive uniqueness.
+func NormalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+func (p *CredentialPolicy) ValidateLogin(login string) []models.FieldError {
+	var fields []models.FieldError
+	add := func(code, message string) {
+		fields = append(fields, models.FieldError{Field: "login", Code: code, Message: message})
+	}
+
+	if login != strings.TrimSpace(login) {
+		add("whitespace", "must not start or end with whitespace")
+	}
+	if strings.IndexFunc(login, unicode.IsControl) >= 0 || !utf8.ValidString(login) {
+		add("charset", "contains invalid characters")
+	} else if p.loginPattern != nil && !p.loginPattern.MatchString(login) {
+		add("charset", "contains characters that are not allowed")
+	}
+
+	length := utf8.RuneCountInString(login)
+	if p.conf.LoginMinLength > 0 && length < p.conf.LoginMinLength {
+		add("too_short", fmt.Sprintf("must be at least %d characters", p.conf.LoginMinLength))
+	}
+	if p.conf.LoginMaxLength > 0 && length > p.conf.LoginMaxLength {
+		add("too_long", fmt.Sprintf("must be at most %d characters", p.conf.LoginMaxLength))
+	}
+
+	return fields
+}
+
+// ValidatePassword checks password strength. login may be empty when it is
+// not known, in which case the password is not compared with it.
+func (p *CredentialPolicy) ValidatePassword(login, password string) []models.FieldError {
+	var fields []models.FieldError
+	add := func(code, message string) {
+		fields = append(fields, models.FieldError{Field: "password", Code: code, Message: message})
+	}
+
+	if p.conf.PasswordMinLength > 0 && utf8.RuneCountInString(password) < p.conf.PasswordMinLength {
+		add("too_short", fmt.Sprintf("must be at least %d characters", p.conf.PasswordMinLength))
+	}
+	if p.conf.PasswordMinClasses > 0 && characterClasses(password) < p.conf.PasswordMinClasses {
+		add("too_simple", fmt.Sprintf("must mix at least %d of lowercase, uppercase, digits and symbols", p.conf.PasswordMinClasses))
+	}
+	if _, denied := p.denyList[strings.ToLower(password)]; denied {
+		add("common", "is too common")
+	}
+	if login != "" && NormalizeLogin(password) == NormalizeLogin(login) {
+		add("same_as_login", "must differ from the login")
+	}
+
+	return fields
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
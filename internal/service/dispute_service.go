@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+)
+
+var (
+	ErrInvalidDisputeRequest    = errors.New("dispute message is required and must be at most 2000 characters")
+	ErrDisputeNotAllowed        = errors.New("only invalid orders or orders claimed by another user can be disputed")
+	ErrDisputeAlreadyOpen       = errors.New("a dispute for this order is already open")
+	ErrDisputeNotFound          = errors.New("dispute not found")
+	ErrDisputeClosed            = errors.New("dispute is already closed")
+	ErrInvalidDisputeResolution = errors.New("resolution is not applicable to this dispute")
+	ErrInvalidDisputeStatus     = errors.New("dispute status must be OPEN, RESOLVED or REJECTED")
+)
+
+const (
+	maxDisputeMessageLength = 2000
+	maxAttachmentRefLength  = 512
+)
+
+type DisputeService struct {
+	repo *repository.DBStorage
+}
+
+func NewDisputeService(repo *repository.DBStorage) *DisputeService {
+	return &DisputeService{repo: repo}
+}
+
+func (s *DisputeService) OpenDispute(userID int, req models.DisputeRequest, clientIP string) (*models.DisputeResponse, error) {
+	if req.Message == "" ||
+		utf8.RuneCountInString(req.Message) > maxDisputeMessageLength ||
+		utf8.RuneCountInString(req.AttachmentRef) > maxAttachmentRefLength {
+		return nil, ErrInvalidDisputeRequest
+	}
+
+	orderNum := NormalizeOrderNumber(req.Order)
+	order, err := s.repo.GetActiveOrder(orderNum)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+
+	var reason string
+	switch {
+	case order.UserID != userID:
+		reason = repository.DisputeReasonClaimedByOther
+	case order.Status == "INVALID":
+		reason = repository.DisputeReasonInvalid
+	default:
+		return nil, ErrDisputeNotAllowed
+	}
+
+	dispute, err := s.repo.CreateDispute(repository.Dispute{
+		UserID:        userID,
+		OrderID:       order.ID,
+		OrderNumber:   orderNum,
+		Reason:        reason,
+		Message:       req.Message,
+		AttachmentRef: req.AttachmentRef,
+	}, clientIP)
+	if err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, ErrDisputeAlreadyOpen
+		}
+		return nil, err
+	}
+
+	resp := newDisputeResponse(dispute)
+	resp.UserID = 0
+	return &resp, nil
+}
+
+func (s *DisputeService) GetUserDisputes(userID int) ([]models.DisputeResponse, error) {
+	disputes, err := s.repo.GetUserDisputes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.DisputeResponse, 0, len(disputes))
+	for _, dispute := range disputes {
+		resp := newDisputeResponse(dispute)
+		resp.UserID = 0
+		responses = append(responses, resp)
+	}
+
+	return responses, nil
+}
+
+func (s *DisputeService) GetDisputeQueue(status string) ([]models.DisputeResponse, error) {
+	switch status {
+	case "":
+		status = "OPEN"
+	case "OPEN", "RESOLVED", "REJECTED":
+	default:
+		return nil, ErrInvalidDisputeStatus
+	}
+
+	disputes, err := s.repo.GetDisputes(status)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.DisputeResponse, 0, len(disputes))
+	for _, dispute := range disputes {
+		responses = append(responses, newDisputeResponse(dispute))
+	}
+
+	return responses, nil
+}
+
+func (s *DisputeService) ResolveDispute(disputeID int, req models.DisputeResolutionRequest, actor, actorIP string) (*models.DisputeResponse, error) {
+	dispute, err := s.repo.ResolveDispute(disputeID, repository.DisputeResolution{
+		Action:  req.Action,
+		Amount:  req.Amount,
+		Note:    req.Note,
+		Actor:   actor,
+		ActorIP: actorIP,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			return nil, ErrDisputeNotFound
+		case errors.Is(err, repository.ErrDisputeClosed):
+			return nil, ErrDisputeClosed
+		case errors.Is(err, repository.ErrInvalidResolution):
+			return nil, ErrInvalidDisputeResolution
+		case errors.Is(err, repository.ErrInsufficientFunds):
+			return nil, ErrInsufficientFunds
+		}
+		return nil, err
+	}
+
+	resp := newDisputeResponse(dispute)
+	return &resp, nil
+}
+
+func newDisputeResponse(dispute repository.Dispute) models.DisputeResponse {
+	return models.DisputeResponse{
+		ID:            dispute.ID,
+		UserID:        dispute.UserID,
+		Order:         dispute.OrderNumber,
+		Reason:        dispute.Reason,
+		Message:       dispute.Message,
+		AttachmentRef: dispute.AttachmentRef,
+		Status:        dispute.Status,
+		Resolution:    dispute.Resolution,
+		Note:          dispute.ResolutionNote,
+		CreatedAt:     dispute.CreatedAt,
+		ResolvedAt:    dispute.ResolvedAt,
+	}
+}
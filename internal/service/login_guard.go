@@ -0,0 +1,200 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrLoginThrottled        = errors.New("too many failed login attempts")
+	ErrLoginGuardUnavailable = errors.New("login attempts cannot be checked")
+)
+
+const (
+	loginDelayBase = time.Second
+	loginDelayMax  = time.Minute
+)
+
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrLoginThrottled, e.RetryAfter)
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+// LoginGuardConfig controls failed login tracking. The first FreeAttempts
+// failures are not throttled, subsequent ones impose an exponentially
+// growing delay, and reaching MaxAttempts for a login (IPMaxAttempts for a
+// client IP) locks it for Lockout. Counters reset after Window without
+// failures. FailOpen lets attempts through when the counters cannot be
+// read, so that an outage does not lock everybody out.
+type LoginGuardConfig struct {
+	FreeAttempts  int
+	MaxAttempts   int
+	IPMaxAttempts int
+	Lockout       time.Duration
+	Window        time.Duration
+	FailOpen      bool
+}
+
+type LoginGuard struct {
+	repo *repository.DBStorage
+	conf LoginGuardConfig
+}
+
+func NewLoginGuard(repo *repository.DBStorage, conf LoginGuardConfig) *LoginGuard {
+	return &LoginGuard{repo: repo, conf: conf}
+}
+
+// LoginAttempt is an attempt reserved by Begin. It counts as a failure until
+// Succeed or Release gives it back.
+type LoginAttempt struct {
+	guard    *LoginGuard
+	reserved []reservedKey
+}
+
+type reservedKey struct {
+	key         string
+	maxAttempts int
+	failures    int
+	lockedUntil *time.Time
+}
+
+// Begin reserves an attempt for the login and the client IP before the
+// password is checked. It returns a *LoginThrottledError when either of them
+// is locked.
+func (g *LoginGuard) Begin(login, ip string) (*LoginAttempt, error) {
+	return g.begin(login, ip, loginKey(login))
+}
+
+// BeginSecondFactor throttles two-factor codes submitted for a user the
+// same way passwords are throttled for a login.
+func (g *LoginGuard) BeginSecondFactor(userID int, ip string) (*LoginAttempt, error) {
+	return g.begin(fmt.Sprint(userID), ip, secondFactorKey(userID))
+}
+
+func (g *LoginGuard) begin(login, ip, key string) (*LoginAttempt, error) {
+	attempt := &LoginAttempt{guard: g}
+
+	for _, k := range []reservedKey{
+		{key: key, maxAttempts: g.conf.MaxAttempts},
+		{key: ipKey(ip), maxAttempts: g.conf.IPMaxAttempts},
+	} {
+		reservation, err := g.repo.ReserveLoginAttempt(k.key, g.conf.Window, func(failures int) time.Duration {
+			return g.delay(failures, k.maxAttempts)
+		})
+		if err != nil {
+			attempt.Release()
+			logger.Log.Error("failed to reserve login attempt",
+				zap.String("key", k.key),
+				zap.Bool("fail_open", g.conf.FailOpen),
+				zap.Error(err))
+			if !g.conf.FailOpen {
+				return nil, ErrLoginGuardUnavailable
+			}
+			return attempt, nil
+		}
+
+		if reservation.RetryAfter > 0 {
+			attempt.Release()
+			logger.Log.Warn("login attempt rejected while throttled",
+				zap.String("login", login),
+				zap.String("ip", ip),
+				zap.Duration("retry_after", reservation.RetryAfter))
+			return nil, &LoginThrottledError{RetryAfter: reservation.RetryAfter}
+		}
+
+		k.failures = reservation.Failures
+		k.lockedUntil = reservation.LockedUntil
+		attempt.reserved = append(attempt.reserved, k)
+	}
+
+	return attempt, nil
+}
+
+func (g *LoginGuard) delay(failures, maxAttempts int) time.Duration {
+	if maxAttempts > 0 && failures >= maxAttempts {
+		return g.conf.Lockout
+	}
+	if failures <= g.conf.FreeAttempts {
+		return 0
+	}
+
+	delay := loginDelayBase
+	for i := g.conf.FreeAttempts + 1; i < failures && delay < loginDelayMax; i++ {
+		delay *= 2
+	}
+	return min(delay, loginDelayMax)
+}
+
+// Fail keeps the reserved attempt counted.
+func (a *LoginAttempt) Fail() {
+	for _, k := range a.reserved {
+		logger.Log.Info("failed login attempt", zap.String("key", k.key), zap.Int("failures", k.failures))
+		if k.maxAttempts > 0 && k.failures >= k.maxAttempts {
+			logger.Log.Warn("login locked out",
+				zap.String("key", k.key),
+				zap.Int("failures", k.failures),
+				zap.Duration("duration", a.guard.conf.Lockout))
+		}
+	}
+	a.reserved = nil
+}
+
+// Succeed clears the failure counter of the login. The IP counter only gets
+// the reserved attempt back, so that a valid account cannot be used to reset
+// it.
+func (a *LoginAttempt) Succeed() {
+	for i, k := range a.reserved {
+		if i == 0 {
+			a.guard.reset(k.key)
+			continue
+		}
+		a.guard.release(k)
+	}
+	a.reserved = nil
+}
+
+// Release gives the reserved attempt back without counting it either way,
+// for attempts that could not be checked.
+func (a *LoginAttempt) Release() {
+	for _, k := range a.reserved {
+		a.guard.release(k)
+	}
+	a.reserved = nil
+}
+
+func (g *LoginGuard) release(k reservedKey) {
+	if err := g.repo.ReleaseLoginAttempt(k.key, k.lockedUntil); err != nil {
+		logger.Log.Error("failed to release login attempt", zap.String("key", k.key), zap.Error(err))
+	}
+}
+
+func (g *LoginGuard) reset(key string) {
+	if err := g.repo.ResetLoginAttempts(key, g.conf.Window); err != nil {
+		logger.Log.Error("failed to reset login attempts", zap.Error(err))
+	}
+}
+
+func loginKey(login string) string {
+	return "login:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+func secondFactorKey(userID int) string {
+	return fmt.Sprintf("2fa:%d", userID)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/oidc"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnknownProvider      = errors.New("unknown identity provider")
+	ErrInvalidOIDCState     = errors.New("invalid or expired login state")
+	ErrOIDCIdentityLinked   = errors.New("identity is linked to another account")
+	ErrOIDCProviderFailed   = errors.New("identity provider request failed")
+	ErrOIDCIdentityRejected = errors.New("identity provider response rejected")
+)
+
+type OIDCLogin struct {
+	UserID int
+	// Linked is set when the callback completed a link started by a
+	// signed-in user rather than a sign-in.
+	Linked  bool
+	Created bool
+}
+
+// OIDCStore keeps pending sign-ins and external identities.
+type OIDCStore interface {
+	SaveOIDCState(stateHash string, state repository.OIDCState, expiresAt time.Time) error
+	ConsumeOIDCState(stateHash string) (repository.OIDCState, error)
+	ResolveIdentity(identity repository.ExternalIdentity) (int, bool, error)
+}
+
+type OIDCService struct {
+	repo      OIDCStore
+	providers map[string]*oidc.Provider
+	stateTTL  time.Duration
+	policy    *CredentialPolicy
+}
+
+func NewOIDCService(repo OIDCStore, providers map[string]*oidc.Provider, stateTTL time.Duration, policy *CredentialPolicy) *OIDCService {
+	return &OIDCService{repo: repo, providers: providers, stateTTL: stateTTL, policy: policy}
+}
+
+// Start begins an authorization-code flow and returns the provider URL to
+// redirect to along with the state, which the caller must bind to the
+// browser. linkUserID is the signed-in user linking an identity, or zero.
+func (s *OIDCService) Start(providerName string, linkUserID int) (string, string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(48)
+	if err != nil {
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		logger.Log.Error("oidc discovery failed", zap.String("provider", providerName), zap.Error(err))
+		return "", "", ErrOIDCProviderFailed
+	}
+
+	err = s.repo.SaveOIDCState(hashToken(state), repository.OIDCState{
+		Provider:     providerName,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		LinkUserID:   linkUserID,
+	}, time.Now().Add(s.stateTTL))
+	if err != nil {
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+func (s *OIDCService) Callback(providerName, state, code string) (*OIDCLogin, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	if state == "" || code == "" {
+		return nil, ErrInvalidOIDCState
+	}
+
+	pending, err := s.repo.ConsumeOIDCState(hashToken(state))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+	if pending.Provider != providerName {
+		return nil, ErrInvalidOIDCState
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	identity, err := provider.Exchange(ctx, code, pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		logger.Log.Warn("oidc code exchange failed", zap.String("provider", providerName), zap.Error(err))
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			return nil, ErrOIDCIdentityRejected
+		}
+		return nil, ErrOIDCProviderFailed
+	}
+
+	external := repository.ExternalIdentity{
+		Provider:   providerName,
+		Subject:    identity.Subject,
+		LinkUserID: pending.LinkUserID,
+	}
+	if identity.EmailVerified {
+		external.Email = identity.Email
+		if provider.LinkByEmail() {
+			external.EmailLogin = NormalizeLogin(identity.Email)
+		}
+	}
+	external.NewLogins = s.newLogins(external)
+
+	userID, created, err := s.repo.ResolveIdentity(external)
+	if err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, ErrOIDCIdentityLinked
+		}
+		return nil, err
+	}
+
+	logger.Log.Info("oidc sign-in completed",
+		zap.String("provider", providerName),
+		zap.Int("user_id", userID),
+		zap.Bool("linked", pending.LinkUserID != 0),
+		zap.Bool("created", created))
+
+	return &OIDCLogin{UserID: userID, Linked: pending.LinkUserID != 0, Created: created}, nil
+}
+
+// newLogins lists the logins an account created on first sign-in may take:
+// the verified email when it passes the login policy, then the
+// provider-scoped subject. The latter is exempt from the policy: it is not
+// chosen by the user, nobody signs in with it as a password login, and it
+// keeps sign-in working when the pattern rejects every email.
+func (s *OIDCService) newLogins(identity repository.ExternalIdentity) []models.UserDB {
+	var logins []models.UserDB
+	if identity.Email != "" && len(s.policy.ValidateLogin(identity.Email)) == 0 {
+		logins = append(logins, models.UserDB{
+			Login:           identity.Email,
+			LoginNormalized: NormalizeLogin(identity.Email),
+		})
+	}
+
+	fallback := identity.Provider + ":" + identity.Subject
+	return append(logins, models.UserDB{
+		Login:           fallback,
+		LoginNormalized: NormalizeLogin(fallback),
+	})
+}
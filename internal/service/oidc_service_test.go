@@ -0,0 +1,298 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/oidc"
+	"github.com/mdflamingo/Gofermart/internal/oidc/oidctest"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+)
+
+const testClientID = "gophermart"
+
+type pendingOIDCState struct {
+	state     repository.OIDCState
+	expiresAt time.Time
+}
+
+// fakeOIDCStore keeps states and identities in memory with the semantics of
+// the Postgres implementation.
+type fakeOIDCStore struct {
+	states     map[string]pendingOIDCState
+	users      map[string]int
+	identities map[string]int
+	nextUserID int
+}
+
+func newFakeOIDCStore() *fakeOIDCStore {
+	return &fakeOIDCStore{
+		states:     make(map[string]pendingOIDCState),
+		users:      make(map[string]int),
+		identities: make(map[string]int),
+		nextUserID: 100,
+	}
+}
+
+func (f *fakeOIDCStore) SaveOIDCState(stateHash string, state repository.OIDCState, expiresAt time.Time) error {
+	f.states[stateHash] = pendingOIDCState{state: state, expiresAt: expiresAt}
+	return nil
+}
+
+func (f *fakeOIDCStore) ConsumeOIDCState(stateHash string) (repository.OIDCState, error) {
+	pending, ok := f.states[stateHash]
+	delete(f.states, stateHash)
+	if !ok || !pending.expiresAt.After(time.Now()) {
+		return repository.OIDCState{}, repository.ErrNotFound
+	}
+	return pending.state, nil
+}
+
+func (f *fakeOIDCStore) ResolveIdentity(identity repository.ExternalIdentity) (int, bool, error) {
+	key := identity.Provider + "|" + identity.Subject
+	if userID, ok := f.identities[key]; ok {
+		if identity.LinkUserID != 0 && identity.LinkUserID != userID {
+			return 0, false, repository.ErrConflict
+		}
+		return userID, false, nil
+	}
+
+	userID, created := identity.LinkUserID, false
+	if userID == 0 && identity.EmailLogin != "" {
+		userID = f.users[identity.EmailLogin]
+	}
+	if userID == 0 {
+		for _, login := range identity.NewLogins {
+			if _, taken := f.users[login.LoginNormalized]; taken {
+				continue
+			}
+			f.nextUserID++
+			userID, created = f.nextUserID, true
+			f.users[login.LoginNormalized] = userID
+			break
+		}
+		if userID == 0 {
+			return 0, false, repository.ErrConflict
+		}
+	}
+
+	f.identities[key] = userID
+	return userID, created, nil
+}
+
+func (f *fakeOIDCStore) loginOf(userID int) string {
+	for login, id := range f.users {
+		if id == userID {
+			return login
+		}
+	}
+	return ""
+}
+
+type oidcFixture struct {
+	idp   *oidctest.Server
+	store *fakeOIDCStore
+	svc   *OIDCService
+}
+
+func newOIDCFixture(t *testing.T, linkByEmail bool, stateTTL time.Duration, policyConf CredentialPolicyConfig) *oidcFixture {
+	t.Helper()
+
+	idp := oidctest.NewServer(t, testClientID)
+	provider, err := oidc.NewProvider(oidc.ProviderConfig{
+		Name:        "stub",
+		Issuer:      idp.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost/api/user/oidc/stub/callback",
+		LinkByEmail: linkByEmail,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+
+	policy, err := NewCredentialPolicy(policyConf)
+	if err != nil {
+		t.Fatalf("NewCredentialPolicy: %v", err)
+	}
+
+	store := newFakeOIDCStore()
+	return &oidcFixture{
+		idp:   idp,
+		store: store,
+		svc:   NewOIDCService(store, map[string]*oidc.Provider{"stub": provider}, stateTTL, policy),
+	}
+}
+
+// signIn starts a flow for linkUserID and has the stub approve it with
+// claims, returning the state and code the callback receives.
+func (f *oidcFixture) signIn(t *testing.T, linkUserID int, claims map[string]any) (state, code string) {
+	t.Helper()
+
+	authURL, state, err := f.svc.Start("stub", linkUserID)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	return state, f.idp.Authorize(t, authURL, claims)
+}
+
+var defaultLoginPolicy = CredentialPolicyConfig{LoginMinLength: 3, LoginMaxLength: 255}
+
+func aliceClaims() map[string]any {
+	return map[string]any{"sub": "alice-sub", "email": "Alice@Example.com", "email_verified": true}
+}
+
+func TestOIDCCallbackCreatesUser(t *testing.T) {
+	f := newOIDCFixture(t, false, time.Minute, defaultLoginPolicy)
+
+	state, code := f.signIn(t, 0, aliceClaims())
+	login, err := f.svc.Callback("stub", state, code)
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+
+	if !login.Created || login.Linked {
+		t.Errorf("login = %+v, want a created, unlinked user", *login)
+	}
+	if got := f.store.loginOf(login.UserID); got != "alice@example.com" {
+		t.Errorf("normalized login = %q, want %q", got, "alice@example.com")
+	}
+
+	// Signing in again finds the identity instead of creating a user.
+	state, code = f.signIn(t, 0, aliceClaims())
+	again, err := f.svc.Callback("stub", state, code)
+	if err != nil {
+		t.Fatalf("second Callback: %v", err)
+	}
+	if again.UserID != login.UserID || again.Created {
+		t.Errorf("second login = %+v, want existing user %d", *again, login.UserID)
+	}
+}
+
+func TestOIDCCallbackUsesSubjectWhenEmailFailsLoginPolicy(t *testing.T) {
+	policy := defaultLoginPolicy
+	policy.LoginPattern = `^[a-z]+$`
+	f := newOIDCFixture(t, false, time.Minute, policy)
+
+	state, code := f.signIn(t, 0, aliceClaims())
+	login, err := f.svc.Callback("stub", state, code)
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+
+	if got := f.store.loginOf(login.UserID); got != "stub:alice-sub" {
+		t.Errorf("login = %q, want the provider-scoped subject", got)
+	}
+}
+
+func TestOIDCCallbackRejectsReusedState(t *testing.T) {
+	f := newOIDCFixture(t, false, time.Minute, defaultLoginPolicy)
+
+	state, code := f.signIn(t, 0, aliceClaims())
+	if _, err := f.svc.Callback("stub", state, code); err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+
+	_, err := f.svc.Callback("stub", state, code)
+	if !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("reused state error = %v, want %v", err, ErrInvalidOIDCState)
+	}
+}
+
+func TestOIDCCallbackRejectsExpiredState(t *testing.T) {
+	f := newOIDCFixture(t, false, -time.Second, defaultLoginPolicy)
+
+	state, code := f.signIn(t, 0, aliceClaims())
+	_, err := f.svc.Callback("stub", state, code)
+	if !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("expired state error = %v, want %v", err, ErrInvalidOIDCState)
+	}
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	f := newOIDCFixture(t, false, time.Minute, defaultLoginPolicy)
+
+	claims := aliceClaims()
+	claims["nonce"] = "replayed-nonce"
+	state, code := f.signIn(t, 0, claims)
+
+	_, err := f.svc.Callback("stub", state, code)
+	if !errors.Is(err, ErrOIDCIdentityRejected) {
+		t.Fatalf("nonce mismatch error = %v, want %v", err, ErrOIDCIdentityRejected)
+	}
+}
+
+func TestOIDCCallbackLinkByEmail(t *testing.T) {
+	const existingUserID = 7
+
+	tests := []struct {
+		name          string
+		linkByEmail   bool
+		emailVerified bool
+		wantExisting  bool
+		wantLogin     string
+	}{
+		{name: "enabled", linkByEmail: true, emailVerified: true, wantExisting: true},
+		{name: "disabled", linkByEmail: false, emailVerified: true, wantLogin: "stub:alice-sub"},
+		{name: "unverified email", linkByEmail: true, emailVerified: false, wantLogin: "stub:alice-sub"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOIDCFixture(t, tt.linkByEmail, time.Minute, defaultLoginPolicy)
+			f.store.users["alice@example.com"] = existingUserID
+
+			claims := aliceClaims()
+			claims["email_verified"] = tt.emailVerified
+			state, code := f.signIn(t, 0, claims)
+
+			login, err := f.svc.Callback("stub", state, code)
+			if err != nil {
+				t.Fatalf("Callback: %v", err)
+			}
+
+			if tt.wantExisting {
+				if login.UserID != existingUserID || login.Created {
+					t.Errorf("login = %+v, want existing user %d", *login, existingUserID)
+				}
+				return
+			}
+			if login.UserID == existingUserID || !login.Created {
+				t.Errorf("login = %+v, want a new user", *login)
+			}
+			if got := f.store.loginOf(login.UserID); got != tt.wantLogin {
+				t.Errorf("login = %q, want %q", got, tt.wantLogin)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackLinksSignedInUser(t *testing.T) {
+	f := newOIDCFixture(t, false, time.Minute, defaultLoginPolicy)
+
+	state, code := f.signIn(t, 42, aliceClaims())
+	login, err := f.svc.Callback("stub", state, code)
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+	if login.UserID != 42 || !login.Linked {
+		t.Errorf("login = %+v, want identity linked to user 42", *login)
+	}
+
+	// The same identity cannot then be linked to someone else.
+	state, code = f.signIn(t, 43, aliceClaims())
+	if _, err := f.svc.Callback("stub", state, code); !errors.Is(err, ErrOIDCIdentityLinked) {
+		t.Fatalf("second link error = %v, want %v", err, ErrOIDCIdentityLinked)
+	}
+}
+
+func TestOIDCCallbackRejectsStateFromAnotherProvider(t *testing.T) {
+	f := newOIDCFixture(t, false, time.Minute, defaultLoginPolicy)
+
+	state, code := f.signIn(t, 0, aliceClaims())
+	f.svc.providers["other"] = f.svc.providers["stub"]
+
+	if _, err := f.svc.Callback("other", state, code); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("Callback error = %v, want %v", err, ErrInvalidOIDCState)
+	}
+}
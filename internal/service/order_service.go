@@ -2,6 +2,8 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/mdflamingo/Gofermart/internal/models"
 	"github.com/mdflamingo/Gofermart/internal/repository"
@@ -12,22 +14,70 @@ var (
 	ErrOrderAlreadyUploadedByUser = errors.New("order already uploaded by this user")
 	ErrOrderAlreadyUploadedByOther = errors.New("order already uploaded by another user")
 	ErrOrderNotFound              = errors.New("order not found")
+	ErrEmptyBatch                 = errors.New("batch contains no order numbers")
+	ErrBatchTooLarge              = errors.New("batch contains too many order numbers")
+	ErrInvalidOrderMetadata       = errors.New("invalid order metadata")
+	ErrOrderNotCancellable        = errors.New("order is already being processed")
+)
+
+const (
+	MaxBatchSize      = 1000
+	maxStoreIDLength  = 64
+	maxOrderItems     = 100
+	purchaseClockSkew = 5 * time.Minute
 )
 
 type OrderService struct {
-	repo *repository.DBStorage
+	repo      *repository.DBStorage
+	validator OrderNumberValidator
+	// receiptLocation is the zone of receipt times, which carry no offset.
+	receiptLocation *time.Location
 }
 
-func NewOrderService(repo *repository.DBStorage) *OrderService {
-	return &OrderService{repo: repo}
+func NewOrderService(repo *repository.DBStorage, validator OrderNumberValidator, receiptLocation *time.Location) *OrderService {
+	return &OrderService{repo: repo, validator: validator, receiptLocation: receiptLocation}
 }
 
-func (s *OrderService) UploadOrder(orderNum string, userID int) (int, error) {
-	if err := ValidateOrderNumber(orderNum); err != nil {
+func (s *OrderService) UploadOrder(orderNum string, userID int, meta *models.OrderMetadata) (int, error) {
+	orderNum, err := checkOrderNumber(s.validator, orderNum)
+	if err != nil {
 		return 0, err
 	}
 
-	ownerID, err := s.repo.SaveOrder(orderNum, userID)
+	if meta != nil {
+		if err := validateOrderMetadata(meta); err != nil {
+			return 0, err
+		}
+	}
+
+	return s.saveOrder(orderNum, userID, meta)
+}
+
+func (s *OrderService) UploadReceipt(qr string, userID int) (string, error) {
+	receipt, err := ParseFiscalReceipt(qr, s.receiptLocation)
+	if err != nil {
+		return "", err
+	}
+
+	orderNum, err := checkOrderNumber(s.validator, receipt.OrderNumber())
+	if err != nil {
+		return orderNum, err
+	}
+
+	meta := &models.OrderMetadata{
+		Amount:      &receipt.Amount,
+		PurchasedAt: &receipt.Time,
+	}
+	if err := validateOrderMetadata(meta); err != nil {
+		return orderNum, err
+	}
+
+	_, err = s.saveOrder(orderNum, userID, meta)
+	return orderNum, err
+}
+
+func (s *OrderService) saveOrder(orderNum string, userID int, meta *models.OrderMetadata) (int, error) {
+	ownerID, err := s.repo.SaveOrder(orderNum, userID, meta)
 	if err != nil {
 		if errors.Is(err, repository.ErrConflict) {
 			if ownerID == userID {
@@ -41,6 +91,70 @@ func (s *OrderService) UploadOrder(orderNum string, userID int) (int, error) {
 	return ownerID, nil
 }
 
+func (s *OrderService) UploadOrders(orderNums []string, userID int) ([]models.BatchOrderResult, error) {
+	if len(orderNums) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(orderNums) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]models.BatchOrderResult, len(orderNums))
+	seen := make(map[string]bool, len(orderNums))
+	toSave := make([]string, 0, len(orderNums))
+
+	for i, orderNum := range orderNums {
+		orderNum, err := checkOrderNumber(s.validator, orderNum)
+		results[i].Number = orderNum
+		if err != nil {
+			results[i].Status = models.BatchOrderInvalid
+			continue
+		}
+		if !seen[orderNum] {
+			seen[orderNum] = true
+			toSave = append(toSave, orderNum)
+		}
+	}
+
+	if len(toSave) == 0 {
+		return results, nil
+	}
+
+	saved, err := s.repo.SaveOrders(toSave, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(saved))
+	for _, order := range saved {
+		switch {
+		case order.Inserted:
+			statuses[order.Number] = models.BatchOrderAccepted
+		case order.UserID == userID:
+			statuses[order.Number] = models.BatchOrderAlreadyYours
+		default:
+			statuses[order.Number] = models.BatchOrderOwnedByAnother
+		}
+	}
+
+	// A repeated number reports what its first occurrence found, except that
+	// a number accepted earlier in the batch is by then already the user's.
+	reported := make(map[string]bool, len(toSave))
+	for i := range results {
+		if results[i].Status != "" {
+			continue
+		}
+		status := statuses[results[i].Number]
+		if reported[results[i].Number] && status == models.BatchOrderAccepted {
+			status = models.BatchOrderAlreadyYours
+		}
+		reported[results[i].Number] = true
+		results[i].Status = status
+	}
+
+	return results, nil
+}
+
 func (s *OrderService) GetUserOrders(userID int) ([]models.OrdersResponse, error) {
 	orders, err := s.repo.GetOrders(userID)
 	if err != nil {
@@ -50,18 +164,31 @@ func (s *OrderService) GetUserOrders(userID int) ([]models.OrdersResponse, error
 	responses := make([]models.OrdersResponse, 0, len(orders))
 	for _, order := range orders {
 		responses = append(responses, models.OrdersResponse{
-			Number:     order.Number,
-			Status:     order.Status,
-			Accrual:    order.Accrual,
-			UploadedAt: order.UploadedAt,
+			Number:        order.Number,
+			Status:        order.Status,
+			Accrual:       order.Accrual,
+			UploadedAt:    order.UploadedAt,
+			OrderMetadata: order.Metadata,
 		})
 	}
 
 	return responses, nil
 }
 
-func (s *OrderService) GetOrder(orderNum string) (*models.OrderInfoResponse, error) {
-	order, err := s.repo.GetOrder(orderNum)
+func (s *OrderService) GetOrder(orderNum string, userID int) (*models.OrderInfoResponse, error) {
+	order, err := s.repo.GetUserOrder(NormalizeOrderNumber(orderNum), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+
+	return newOrderInfoResponse(order), nil
+}
+
+func (s *OrderService) GetAnyOrder(orderNum string) (*models.OrderInfoResponse, error) {
+	order, err := s.repo.GetOrder(NormalizeOrderNumber(orderNum))
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
 			return nil, ErrOrderNotFound
@@ -69,6 +196,66 @@ func (s *OrderService) GetOrder(orderNum string) (*models.OrderInfoResponse, err
 		return nil, err
 	}
 
+	return newOrderInfoResponse(order), nil
+}
+
+func (s *OrderService) CancelOrder(orderNum string, userID int) error {
+	err := s.repo.CancelOrder(NormalizeOrderNumber(orderNum), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			return ErrOrderNotFound
+		case errors.Is(err, repository.ErrNotCancellable):
+			return ErrOrderNotCancellable
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *OrderService) GetOrderHistory(orderNum string, userID int) ([]models.OrderStatusChangeResponse, error) {
+	history, err := s.repo.GetOrderHistory(NormalizeOrderNumber(orderNum), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+
+	responses := make([]models.OrderStatusChangeResponse, 0, len(history))
+	for _, change := range history {
+		responses = append(responses, models.OrderStatusChangeResponse{
+			Status:    change.Status,
+			Source:    change.Source,
+			ChangedAt: change.ChangedAt,
+		})
+	}
+
+	return responses, nil
+}
+
+func validateOrderMetadata(meta *models.OrderMetadata) error {
+	if len(meta.StoreID) > maxStoreIDLength {
+		return fmt.Errorf("%w: store_id is too long", ErrInvalidOrderMetadata)
+	}
+	if meta.Amount != nil && *meta.Amount < 0 {
+		return fmt.Errorf("%w: amount must not be negative", ErrInvalidOrderMetadata)
+	}
+	if meta.PurchasedAt != nil && meta.PurchasedAt.After(time.Now().Add(purchaseClockSkew)) {
+		return fmt.Errorf("%w: purchased_at is in the future", ErrInvalidOrderMetadata)
+	}
+	if len(meta.Items) > maxOrderItems {
+		return fmt.Errorf("%w: too many items", ErrInvalidOrderMetadata)
+	}
+	for _, item := range meta.Items {
+		if item.Name == "" || item.Quantity <= 0 || item.Price < 0 {
+			return fmt.Errorf("%w: invalid item %q", ErrInvalidOrderMetadata, item.Name)
+		}
+	}
+	return nil
+}
+
+func newOrderInfoResponse(order repository.Order) *models.OrderInfoResponse {
 	resp := &models.OrderInfoResponse{
 		Number: order.Number,
 		Status: order.Status,
@@ -78,5 +265,5 @@ func (s *OrderService) GetOrder(orderNum string) (*models.OrderInfoResponse, err
 		resp.Accrual = &order.Accrual
 	}
 
-	return resp, nil
+	return resp
 }
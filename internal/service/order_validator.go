@@ -0,0 +1,208 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type OrderNumberValidator interface {
+	Validate(number string) error
+}
+
+type ValidatorFactory func(params url.Values) (OrderNumberValidator, error)
+
+var (
+	validatorsMu sync.RWMutex
+	validators   = map[string]ValidatorFactory{
+		"luhn":   newLuhnValidator,
+		"digits": newDigitsValidator,
+	}
+)
+
+func RegisterOrderNumberValidator(name string, factory ValidatorFactory) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators[name] = factory
+}
+
+func NormalizeOrderNumber(number string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(number))
+}
+
+type LengthBounds struct {
+	Min int
+	Max int
+}
+
+func (b LengthBounds) check(number string) error {
+	if len(number) < b.Min || (b.Max > 0 && len(number) > b.Max) {
+		return ErrInvalidOrderNumber
+	}
+	return nil
+}
+
+type LuhnValidator struct {
+	LengthBounds
+}
+
+func (v LuhnValidator) Validate(number string) error {
+	if err := v.check(number); err != nil {
+		return err
+	}
+	if !luhnValid(number) {
+		return ErrInvalidOrderNumber
+	}
+	return nil
+}
+
+type DigitsValidator struct {
+	LengthBounds
+}
+
+func (v DigitsValidator) Validate(number string) error {
+	if err := v.check(number); err != nil {
+		return err
+	}
+	if !isDigits(number) {
+		return ErrInvalidOrderNumber
+	}
+	return nil
+}
+
+type prefixRoute struct {
+	prefix    string
+	validator OrderNumberValidator
+}
+
+type PrefixValidator struct {
+	routes   []prefixRoute
+	fallback OrderNumberValidator
+}
+
+func NewPrefixValidator(fallback OrderNumberValidator) *PrefixValidator {
+	return &PrefixValidator{fallback: fallback}
+}
+
+func (v *PrefixValidator) Route(prefix string, validator OrderNumberValidator) {
+	v.routes = append(v.routes, prefixRoute{prefix: prefix, validator: validator})
+	sort.SliceStable(v.routes, func(i, j int) bool {
+		return len(v.routes[i].prefix) > len(v.routes[j].prefix)
+	})
+}
+
+func (v *PrefixValidator) Validate(number string) error {
+	for _, route := range v.routes {
+		if strings.HasPrefix(number, route.prefix) {
+			return route.validator.Validate(number)
+		}
+	}
+	if v.fallback == nil {
+		return ErrInvalidOrderNumber
+	}
+	return v.fallback.Validate(number)
+}
+
+// ParseOrderNumberValidators builds a validator from a spec such as
+// "*=luhn?min=2&max=64;77=digits?min=12&max=12", where each entry routes
+// numbers with the given prefix ("*" for any other number) to a registered
+// validator configured with URL query parameters.
+func ParseOrderNumberValidators(spec string) (OrderNumberValidator, error) {
+	prefixValidator := NewPrefixValidator(nil)
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, definition, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid order number validator %q", entry)
+		}
+
+		name, rawParams, _ := strings.Cut(definition, "?")
+		params, err := url.ParseQuery(rawParams)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameters for order number validator %q: %w", name, err)
+		}
+
+		validatorsMu.RLock()
+		factory, ok := validators[name]
+		validatorsMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown order number validator %q", name)
+		}
+
+		validator, err := factory(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure order number validator %q: %w", name, err)
+		}
+
+		if prefix == "*" {
+			prefixValidator.fallback = validator
+		} else {
+			prefixValidator.Route(prefix, validator)
+		}
+	}
+
+	if prefixValidator.fallback == nil && len(prefixValidator.routes) == 0 {
+		return nil, fmt.Errorf("no order number validators configured")
+	}
+
+	return prefixValidator, nil
+}
+
+func newLuhnValidator(params url.Values) (OrderNumberValidator, error) {
+	bounds, err := parseLengthBounds(params)
+	if err != nil {
+		return nil, err
+	}
+	return LuhnValidator{LengthBounds: bounds}, nil
+}
+
+func newDigitsValidator(params url.Values) (OrderNumberValidator, error) {
+	bounds, err := parseLengthBounds(params)
+	if err != nil {
+		return nil, err
+	}
+	return DigitsValidator{LengthBounds: bounds}, nil
+}
+
+func parseLengthBounds(params url.Values) (LengthBounds, error) {
+	bounds := LengthBounds{Min: 1}
+
+	if value := params.Get("min"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return LengthBounds{}, fmt.Errorf("invalid min length %q", value)
+		}
+		bounds.Min = n
+	}
+
+	if value := params.Get("max"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < bounds.Min {
+			return LengthBounds{}, fmt.Errorf("invalid max length %q", value)
+		}
+		bounds.Max = n
+	}
+
+	return bounds, nil
+}
+
+func checkOrderNumber(validator OrderNumberValidator, number string) (string, error) {
+	normalized := NormalizeOrderNumber(number)
+	if err := validator.Validate(normalized); err != nil {
+		return normalized, ErrInvalidOrderNumber
+	}
+	return normalized, nil
+}
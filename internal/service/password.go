@@ -0,0 +1,132 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type PasswordHasher struct {
+	params    Argon2Params
+	dummyHash string
+}
+
+func NewPasswordHasher(params Argon2Params) (*PasswordHasher, error) {
+	if params.SaltLength == 0 {
+		params.SaltLength = 16
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = 32
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, fmt.Errorf("argon2 memory, iterations and parallelism must be positive")
+	}
+
+	h := &PasswordHasher{params: params}
+
+	dummyHash, err := h.Hash("dummy-password")
+	if err != nil {
+		return nil, err
+	}
+	h.dummyHash = dummyHash
+
+	return h, nil
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches encoded and whether encoded should
+// be replaced with a fresh hash, either because it is a legacy unsalted
+// SHA-256 digest or because it was made with different argon2 parameters.
+func (h *PasswordHasher) Verify(password, encoded string) (bool, bool, error) {
+	if !strings.HasPrefix(encoded, "$argon2id$") {
+		return verifyLegacyPassword(password, encoded), true, nil
+	}
+
+	params, salt, key, err := decodeArgon2Hash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash := params.Memory != h.params.Memory ||
+		params.Iterations != h.params.Iterations ||
+		params.Parallelism != h.params.Parallelism ||
+		params.KeyLength != h.params.KeyLength
+
+	return true, needsRehash, nil
+}
+
+func (h *PasswordHasher) VerifyDummy(password string) {
+	h.Verify(password, h.dummyHash)
+}
+
+func verifyLegacyPassword(password, encoded string) bool {
+	sum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(encoded)) == 1
+}
+
+func decodeArgon2Hash(encoded string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var params Argon2Params
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrInvalidPasswordHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
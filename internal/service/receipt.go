@@ -0,0 +1,115 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidReceipt = errors.New("invalid fiscal receipt")
+
+const (
+	receiptOperationIncome = 1
+	receiptNumberDigits    = 18
+	// maxReceiptAmount is the largest sum orders.purchase_amount, a
+	// DECIMAL(12,2), can hold.
+	maxReceiptAmount = 9999999999.99
+)
+
+var (
+	receiptTimeLayouts  = []string{"20060102T150405", "20060102T1504"}
+	receiptAmountFormat = regexp.MustCompile(`^\d+(\.\d{1,2})?$`)
+)
+
+type FiscalReceipt struct {
+	Time          time.Time
+	Amount        float64
+	FN            string
+	FD            string
+	FP            string
+	OperationType int
+}
+
+// ParseFiscalReceipt reads the query string encoded in a receipt QR code.
+// The receipt time carries no offset and is read in loc.
+func ParseFiscalReceipt(qr string, loc *time.Location) (*FiscalReceipt, error) {
+	values, err := url.ParseQuery(strings.TrimSpace(qr))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidReceipt, err)
+	}
+
+	receipt := &FiscalReceipt{
+		FN: values.Get("fn"),
+		FD: values.Get("i"),
+		FP: values.Get("fp"),
+	}
+
+	if receipt.Time, err = parseReceiptTime(values.Get("t"), loc); err != nil {
+		return nil, err
+	}
+
+	if receipt.Amount, err = parseReceiptAmount(values.Get("s")); err != nil {
+		return nil, err
+	}
+
+	if len(receipt.FN) != 16 || !isDigits(receipt.FN) {
+		return nil, fmt.Errorf("%w: invalid fiscal drive number %q", ErrInvalidReceipt, receipt.FN)
+	}
+	if receipt.FD == "" || len(receipt.FD) > 10 || !isDigits(receipt.FD) {
+		return nil, fmt.Errorf("%w: invalid fiscal document number %q", ErrInvalidReceipt, receipt.FD)
+	}
+	if receipt.FP == "" || len(receipt.FP) > 10 || !isDigits(receipt.FP) {
+		return nil, fmt.Errorf("%w: invalid fiscal sign %q", ErrInvalidReceipt, receipt.FP)
+	}
+
+	receipt.OperationType, err = strconv.Atoi(values.Get("n"))
+	if err != nil || receipt.OperationType != receiptOperationIncome {
+		return nil, fmt.Errorf("%w: unsupported operation type %q", ErrInvalidReceipt, values.Get("n"))
+	}
+
+	return receipt, nil
+}
+
+func parseReceiptTime(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range receiptTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: invalid time %q", ErrInvalidReceipt, value)
+}
+
+// parseReceiptAmount accepts a plain decimal with at most two fractional
+// digits, which rules out signs, exponents, NaN and infinities.
+func parseReceiptAmount(value string) (float64, error) {
+	if !receiptAmountFormat.MatchString(value) {
+		return 0, fmt.Errorf("%w: invalid amount %q", ErrInvalidReceipt, value)
+	}
+
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount <= 0 || amount > maxReceiptAmount {
+		return 0, fmt.Errorf("%w: invalid amount %q", ErrInvalidReceipt, value)
+	}
+	return amount, nil
+}
+
+// OrderNumber derives a stable Luhn-valid order number from the fiscal
+// drive number, document number and fiscal sign, which together identify
+// a receipt uniquely.
+func (r *FiscalReceipt) OrderNumber() string {
+	fn := strings.TrimLeft(r.FN, "0")
+	fd := strings.TrimLeft(r.FD, "0")
+	fp := strings.TrimLeft(r.FP, "0")
+
+	sum := sha256.Sum256([]byte(fn + ":" + fd + ":" + fp))
+	n := binary.BigEndian.Uint64(sum[:8]) % 1_000_000_000_000_000_000
+
+	number := fmt.Sprintf("%0*d", receiptNumberDigits, n)
+	return number + strconv.Itoa(luhnCheckDigit(number))
+}
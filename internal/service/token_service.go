@@ -0,0 +1,242 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mdflamingo/Gofermart/internal/keyring"
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+const (
+	mfaTokenAudience = "gophermart:mfa"
+	mfaTokenTTL      = 5 * time.Minute
+)
+
+type accessTokenClaims struct {
+	UserID   int    `json:"userID"`
+	Version  int    `json:"ver,omitempty"`
+	FamilyID string `json:"fam,omitempty"`
+	Role     string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type TokenService struct {
+	repo       *repository.DBStorage
+	keys       *keyring.Keyring
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokenService(repo *repository.DBStorage, keys *keyring.Keyring, accessTTL, refreshTTL time.Duration) *TokenService {
+	return &TokenService{
+		repo:       repo,
+		keys:       keys,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+func (s *TokenService) IssueTokens(userID int, client models.ClientInfo) (*models.TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshExpiresAt := time.Now().Add(s.refreshTTL)
+	token, err := s.repo.SaveRefreshToken(userID, familyID, hashToken(refreshToken), refreshExpiresAt, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// A sign-in is complete only once tokens are issued, which for users
+	// with two-factor authentication is after the second step.
+	if err := s.repo.UpdateLastLogin(userID); err != nil {
+		logger.Log.Error("failed to record last login", zap.Int("user_id", userID), zap.Error(err))
+	}
+
+	return s.newTokenPair(token, refreshToken, refreshExpiresAt)
+}
+
+func (s *TokenService) Refresh(refreshToken string, client models.ClientInfo) (*models.TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	newRefreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshExpiresAt := time.Now().Add(s.refreshTTL)
+	token, err := s.repo.RotateRefreshToken(hashToken(refreshToken), hashToken(newRefreshToken), refreshExpiresAt, client)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			return nil, ErrInvalidRefreshToken
+		case errors.Is(err, repository.ErrTokenReused):
+			return nil, fmt.Errorf("%w: user %d", ErrRefreshTokenReused, token.UserID)
+		}
+		return nil, err
+	}
+
+	return s.newTokenPair(token, newRefreshToken, refreshExpiresAt)
+}
+
+func (s *TokenService) VerifyAccessToken(tokenString string) (*models.AccessClaims, error) {
+	claims := &accessTokenClaims{}
+	token, err := s.keys.Parse(tokenString, claims, jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	// Every access token is issued for a session; one without is not ours
+	// or predates sessions.
+	if !token.Valid || claims.UserID == 0 || claims.FamilyID == "" || len(claims.Audience) > 0 {
+		return nil, ErrInvalidToken
+	}
+
+	valid, err := s.repo.CheckAccessToken(claims.UserID, claims.Version, claims.ID, claims.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrTokenRevoked
+	}
+
+	return &models.AccessClaims{
+		UserID:    claims.UserID,
+		TokenID:   claims.ID,
+		FamilyID:  claims.FamilyID,
+		Version:   claims.Version,
+		ExpiresAt: claims.ExpiresAt.Time,
+		Role:      claims.Role,
+	}, nil
+}
+
+// IssueMFAToken returns a short-lived token proving that the password step
+// of a two-factor login succeeded. It is not accepted as an access token.
+func (s *TokenService) IssueMFAToken(userID int) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(mfaTokenTTL)
+	claims := accessTokenClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{mfaTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := s.keys.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+func (s *TokenService) VerifyMFAToken(tokenString string) (int, error) {
+	claims := &accessTokenClaims{}
+	token, err := s.keys.Parse(tokenString, claims,
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(mfaTokenAudience))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if !token.Valid || claims.UserID == 0 {
+		return 0, ErrInvalidToken
+	}
+
+	return claims.UserID, nil
+}
+
+func (s *TokenService) Logout(claims *models.AccessClaims) error {
+	return s.repo.RevokeTokenFamily(claims.UserID, claims.FamilyID, claims.TokenID, claims.ExpiresAt)
+}
+
+func (s *TokenService) LogoutEverywhere(userID int) error {
+	return s.repo.RevokeAllTokens(userID)
+}
+
+func (s *TokenService) ListSessions(userID int, currentID string) ([]models.SessionResponse, error) {
+	sessions, err := s.repo.GetSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, models.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == currentID,
+		})
+	}
+	return responses, nil
+}
+
+func (s *TokenService) EndSession(userID int, sessionID string) error {
+	if err := s.repo.EndSession(userID, sessionID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *TokenService) newTokenPair(token repository.RefreshToken, refreshToken string, refreshExpiresAt time.Time) (*models.TokenPair, error) {
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	accessExpiresAt := now.Add(s.accessTTL)
+	claims := accessTokenClaims{
+		UserID:   token.UserID,
+		Version:  token.TokenVersion,
+		FamilyID: token.FamilyID,
+		Role:     token.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(accessExpiresAt),
+		},
+	}
+
+	accessToken, err := s.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
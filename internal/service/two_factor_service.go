@@ -0,0 +1,220 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mdflamingo/Gofermart/internal/logger"
+	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/repository"
+	"github.com/mdflamingo/Gofermart/internal/totp"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTOTPRequired       = errors.New("two-factor code required")
+	ErrInvalidTOTPCode    = errors.New("invalid two-factor code")
+)
+
+const (
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorService struct {
+	repo   *repository.DBStorage
+	guard  *LoginGuard
+	issuer string
+}
+
+// NewTwoFactorService creates the 2FA service. Codes are throttled by guard
+// wherever they are checked.
+func NewTwoFactorService(repo *repository.DBStorage, guard *LoginGuard, issuer string) *TwoFactorService {
+	return &TwoFactorService{repo: repo, guard: guard, issuer: issuer}
+}
+
+func (s *TwoFactorService) Enroll(userID int) (*models.TOTPEnrollResponse, error) {
+	login, err := s.repo.GetUserLogin(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SaveTOTPSecret(userID, secret); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, ErrTOTPAlreadyEnabled
+		}
+		return nil, err
+	}
+
+	return &models.TOTPEnrollResponse{
+		Secret: secret,
+		URI:    totp.URI(s.issuer, login, secret),
+	}, nil
+}
+
+// Confirm enables 2FA once the user proves possession of the secret and
+// returns the recovery codes. They are only stored hashed, so this is the
+// single chance to show them.
+func (s *TwoFactorService) Confirm(userID int, code string) ([]string, error) {
+	settings, err := s.repo.GetUserTOTP(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrTOTPNotEnrolled
+		}
+		return nil, err
+	}
+	if settings.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	step, ok, err := totp.Validate(settings.Secret, code, time.Now(), totpSkew)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	if err := s.repo.EnableTOTP(userID, step, hashes); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, ErrTOTPAlreadyEnabled
+		}
+		return nil, err
+	}
+
+	logger.Log.Info("two-factor authentication enabled", zap.Int("user_id", userID))
+	return codes, nil
+}
+
+func (s *TwoFactorService) Disable(userID int, code, clientIP string) error {
+	if err := s.VerifyCode(userID, code, clientIP); err != nil {
+		return err
+	}
+
+	if err := s.repo.DisableTOTP(userID); err != nil {
+		return err
+	}
+
+	logger.Log.Info("two-factor authentication disabled", zap.Int("user_id", userID))
+	return nil
+}
+
+func (s *TwoFactorService) Enabled(userID int) (bool, error) {
+	settings, err := s.repo.GetUserTOTP(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return settings.Enabled, nil
+}
+
+// VerifyCode accepts either a current TOTP code or an unused recovery code.
+// Each TOTP step and each recovery code can be used only once. Wrong codes
+// count towards the user's second-factor lockout, whichever flow they were
+// submitted from.
+func (s *TwoFactorService) VerifyCode(userID int, code, clientIP string) error {
+	settings, err := s.repo.GetUserTOTP(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrTOTPNotEnabled
+		}
+		return err
+	}
+	if !settings.Enabled {
+		return ErrTOTPNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrTOTPRequired
+	}
+
+	attempt, err := s.guard.BeginSecondFactor(userID, clientIP)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkCode(userID, settings.Secret, code); err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			attempt.Fail()
+		} else {
+			attempt.Release()
+		}
+		return err
+	}
+
+	attempt.Succeed()
+	return nil
+}
+
+func (s *TwoFactorService) checkCode(userID int, secret, code string) error {
+	if len(code) == totp.Digits {
+		step, ok, err := totp.Validate(secret, code, time.Now(), totpSkew)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTOTPCode
+		}
+
+		fresh, err := s.repo.UseTOTPStep(userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			logger.Log.Warn("replayed two-factor code", zap.Int("user_id", userID))
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	used, err := s.repo.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTOTPCode
+	}
+
+	logger.Log.Info("recovery code used", zap.Int("user_id", userID))
+	return nil
+}
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+	return code[:4] + "-" + code[4:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
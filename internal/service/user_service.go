@@ -1,28 +1,48 @@
 package service
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"errors"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/mdflamingo/Gofermart/internal/logger"
 	"github.com/mdflamingo/Gofermart/internal/models"
+	"github.com/mdflamingo/Gofermart/internal/notifier"
 	"github.com/mdflamingo/Gofermart/internal/repository"
+	"go.uber.org/zap"
 )
 
 var (
 	ErrEmptyRequiredField = errors.New("login and password cannot be empty")
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrEmptyPassword      = errors.New("password cannot be empty")
+	ErrWrongPassword      = errors.New("current password is incorrect")
+	ErrInvalidResetToken  = errors.New("invalid or expired reset token")
 )
 
+// maxPendingResets bounds the password reset requests processed in the
+// background at once; requests beyond it are dropped.
+const maxPendingResets = 32
+
 type UserService struct {
-	repo *repository.DBStorage
+	repo     *repository.DBStorage
+	hasher   *PasswordHasher
+	notifier notifier.Notifier
+	resetTTL time.Duration
+	policy   *CredentialPolicy
+	resets   chan struct{}
 }
 
-func NewUserService(repo *repository.DBStorage) *UserService {
-	return &UserService{repo: repo}
+func NewUserService(repo *repository.DBStorage, hasher *PasswordHasher, notifier notifier.Notifier, resetTTL time.Duration, policy *CredentialPolicy) *UserService {
+	return &UserService{
+		repo:     repo,
+		hasher:   hasher,
+		notifier: notifier,
+		resetTTL: resetTTL,
+		policy:   policy,
+		resets:   make(chan struct{}, maxPendingResets),
+	}
 }
 
 func (s *UserService) Register(user models.AuthUser) (int, error) {
@@ -30,11 +50,20 @@ func (s *UserService) Register(user models.AuthUser) (int, error) {
 		return 0, ErrEmptyRequiredField
 	}
 
-	hashedPassword := hashPassword(user.Password)
+	fields := append(s.policy.ValidateLogin(user.Login), s.policy.ValidatePassword(user.Login, user.Password)...)
+	if len(fields) > 0 {
+		return 0, &ValidationError{Fields: fields}
+	}
+
+	hashedPassword, err := s.hasher.Hash(user.Password)
+	if err != nil {
+		return 0, err
+	}
 
 	userDB := models.UserDB{
-		Login:    user.Login,
-		Password: hashedPassword,
+		Login:           user.Login,
+		LoginNormalized: NormalizeLogin(user.Login),
+		Password:        hashedPassword,
 	}
 
 	userID, err := s.repo.SaveUser(userDB)
@@ -57,42 +86,167 @@ func (s *UserService) Login(user models.AuthUser) (int, error) {
 		return 0, ErrEmptyRequiredField
 	}
 
-	hashedPassword := hashPassword(user.Password)
-
-	userDB := models.UserDB{
-		Login:    user.Login,
-		Password: hashedPassword,
-	}
-
-	userID, err := s.repo.GetUser(userDB)
+	credentials, err := s.repo.GetUserByLogin(user.Login, NormalizeLogin(user.Login))
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
+			s.hasher.VerifyDummy(user.Password)
 			return 0, ErrInvalidCredentials
 		}
 		return 0, err
 	}
 
-	return userID, nil
+	ok, needsRehash, err := s.hasher.Verify(user.Password, credentials.PasswordHash)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		s.upgradePasswordHash(credentials.ID, credentials.PasswordHash, user.Password)
+	}
+
+	return credentials.ID, nil
 }
 
-func (s *UserService) GenerateToken(userID int, secretKey string) (string, error) {
-	claims := jwt.MapClaims{
-		"userID": userID,
-		"exp":    time.Now().Add(30 * 24 * time.Hour).Unix(),
-		"iat":    time.Now().Unix(),
+func (s *UserService) upgradePasswordHash(userID int, currentHash, password string) {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		logger.Log.Error("failed to rehash password", zap.Int("user_id", userID), zap.Error(err))
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secretKey))
+	if err := s.repo.UpgradePasswordHash(userID, currentHash, hashedPassword); err != nil {
+		logger.Log.Error("failed to upgrade password hash", zap.Int("user_id", userID), zap.Error(err))
+		return
+	}
+
+	logger.Log.Info("upgraded password hash", zap.Int("user_id", userID))
+}
+
+func (s *UserService) ChangePassword(userID int, req models.ChangePasswordRequest) error {
+	if req.NewPassword == "" {
+		return ErrEmptyPassword
+	}
+
+	credentials, err := s.repo.GetUserCredentials(userID)
+	if err != nil {
+		return err
+	}
+
+	ok, _, err := s.hasher.Verify(req.CurrentPassword, credentials.PasswordHash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrWrongPassword
+	}
+
+	login, err := s.repo.GetUserLogin(userID)
+	if err != nil {
+		return err
+	}
+	if fields := s.policy.ValidatePassword(login, req.NewPassword); len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+
+	hashedPassword, err := s.hasher.Hash(req.NewPassword)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return tokenString, nil
+	return s.repo.ChangePassword(userID, hashedPassword)
 }
 
-func hashPassword(password string) string {
-	h := sha256.New()
-	h.Write([]byte(password))
-	return hex.EncodeToString(h.Sum(nil))
+// RequestPasswordReset never reveals whether the login exists: the lookup,
+// token and notification happen in the background, so known and unknown
+// logins get the same response in the same time.
+func (s *UserService) RequestPasswordReset(login string) error {
+	if login == "" {
+		return ErrEmptyRequiredField
+	}
+
+	select {
+	case s.resets <- struct{}{}:
+	default:
+		logger.Log.Warn("too many pending password resets, request dropped")
+		return nil
+	}
+
+	go func() {
+		defer func() { <-s.resets }()
+		if err := s.issuePasswordReset(login); err != nil {
+			logger.Log.Error("failed to issue password reset", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+func (s *UserService) issuePasswordReset(login string) error {
+	credentials, err := s.repo.GetUserByLogin(login, NormalizeLogin(login))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			logger.Log.Info("password reset requested for unknown login", zap.String("login", login))
+			return nil
+		}
+		return err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(s.resetTTL)
+	if err := s.repo.SavePasswordResetToken(credentials.ID, hashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.notifier.SendPasswordReset(ctx, login, token, expiresAt); err != nil {
+		return err
+	}
+
+	logger.Log.Info("password reset token issued", zap.Int("user_id", credentials.ID))
+	return nil
+}
+
+func (s *UserService) ConfirmPasswordReset(req models.PasswordResetConfirmRequest) (int, error) {
+	if req.Token == "" {
+		return 0, ErrInvalidResetToken
+	}
+	if req.NewPassword == "" {
+		return 0, ErrEmptyPassword
+	}
+
+	login, err := s.repo.GetPasswordResetLogin(hashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return 0, ErrInvalidResetToken
+		}
+		return 0, err
+	}
+	if fields := s.policy.ValidatePassword(login, req.NewPassword); len(fields) > 0 {
+		return 0, &ValidationError{Fields: fields}
+	}
+
+	hashedPassword, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	userID, err := s.repo.ResetPassword(hashToken(req.Token), hashedPassword)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return 0, ErrInvalidResetToken
+		}
+		return 0, err
+	}
+
+	logger.Log.Info("password reset completed", zap.Int("user_id", userID))
+	return userID, nil
 }
@@ -1,15 +1,21 @@
 package service
 
-import "strconv"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+)
 
-func ValidateOrderNumber(number string) error {
+func luhnValid(number string) bool {
 	sum := 0
 	isSecond := false
 
 	for i := len(number) - 1; i >= 0; i-- {
 		digit, err := strconv.Atoi(string(number[i]))
 		if err != nil {
-			return ErrInvalidOrderNumber
+			return false
 		}
 
 		if isSecond {
@@ -23,9 +29,48 @@ func ValidateOrderNumber(number string) error {
 		isSecond = !isSecond
 	}
 
-	if sum%10 != 0 {
-		return ErrInvalidOrderNumber
+	return sum%10 == 0
+}
+
+func luhnCheckDigit(number string) int {
+	sum := 0
+	isSecond := true
+
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+
+		if isSecond {
+			digit = digit * 2
+			if digit > 9 {
+				digit = digit - 9
+			}
+		}
+
+		sum += digit
+		isSecond = !isSecond
+	}
+
+	return (10 - sum%10) % 10
+}
+
+func isDigits(number string) bool {
+	for i := 0; i < len(number); i++ {
+		if number[i] < '0' || number[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	return nil
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
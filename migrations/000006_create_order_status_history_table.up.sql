@@ -0,0 +1,15 @@
+CREATE TABLE order_status_history (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    order_id INT NOT NULL REFERENCES orders(id),
+    status orderstatus NOT NULL,
+    source VARCHAR(32) NOT NULL,
+    changed_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_order_status_history_order_id ON order_status_history(order_id);
+
+INSERT INTO order_status_history (order_id, status, source, changed_at)
+SELECT id, 'NEW', 'upload', uploaded_at FROM orders;
+
+INSERT INTO order_status_history (order_id, status, source)
+SELECT id, status, 'migration' FROM orders WHERE status <> 'NEW';
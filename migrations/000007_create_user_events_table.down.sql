@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_user_events_created_at;
+DROP INDEX IF EXISTS idx_user_events_user_id;
+DROP TABLE IF EXISTS user_events;
@@ -0,0 +1,10 @@
+CREATE TABLE user_events (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id INT NOT NULL REFERENCES users(id),
+    type VARCHAR(32) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_user_events_user_id ON user_events(user_id, id);
+CREATE INDEX idx_user_events_created_at ON user_events(created_at);
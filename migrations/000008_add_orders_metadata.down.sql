@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_orders_store_id;
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS items,
+    DROP COLUMN IF EXISTS purchased_at,
+    DROP COLUMN IF EXISTS purchase_amount,
+    DROP COLUMN IF EXISTS store_id;
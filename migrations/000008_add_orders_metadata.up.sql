@@ -0,0 +1,7 @@
+ALTER TABLE orders
+    ADD COLUMN store_id VARCHAR(64),
+    ADD COLUMN purchase_amount DECIMAL(12,2),
+    ADD COLUMN purchased_at TIMESTAMPTZ,
+    ADD COLUMN items JSONB;
+
+CREATE INDEX idx_orders_store_id ON orders(store_id);
@@ -0,0 +1 @@
+-- PostgreSQL cannot drop a value from an enum type; CANCELLED stays in orderstatus.
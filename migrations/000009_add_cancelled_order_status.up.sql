@@ -0,0 +1 @@
+ALTER TYPE orderstatus ADD VALUE IF NOT EXISTS 'CANCELLED';
@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS idx_orders_number_active;
+
+-- Numbers may have been uploaded again after a cancellation. Only one row
+-- per number can survive the unique constraint: the active one, or else
+-- the latest cancelled one.
+WITH superseded AS (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (
+            PARTITION BY number
+            ORDER BY (status <> 'CANCELLED') DESC, id DESC
+        ) AS rank
+        FROM orders
+    ) ranked
+    WHERE rank > 1
+), history AS (
+    DELETE FROM order_status_history WHERE order_id IN (SELECT id FROM superseded)
+)
+DELETE FROM orders WHERE id IN (SELECT id FROM superseded);
+
+ALTER TABLE orders ADD CONSTRAINT orders_number_key UNIQUE (number);
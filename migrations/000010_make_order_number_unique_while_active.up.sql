@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_number_key;
+
+CREATE UNIQUE INDEX idx_orders_number_active ON orders(number) WHERE status <> 'CANCELLED';
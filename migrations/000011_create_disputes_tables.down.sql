@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_dispute_audit_dispute_id;
+DROP TABLE IF EXISTS dispute_audit;
+DROP INDEX IF EXISTS idx_disputes_status;
+DROP INDEX IF EXISTS idx_disputes_user_id;
+DROP INDEX IF EXISTS idx_disputes_open_order;
+DROP TABLE IF EXISTS disputes;
+DROP TYPE IF EXISTS disputestatus CASCADE;
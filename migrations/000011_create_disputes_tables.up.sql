@@ -0,0 +1,37 @@
+DO $$
+BEGIN
+    CREATE TYPE disputestatus AS ENUM ('OPEN', 'RESOLVED', 'REJECTED');
+EXCEPTION
+    WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE TABLE disputes (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id INT NOT NULL REFERENCES users(id),
+    order_id INT NOT NULL REFERENCES orders(id),
+    order_number VARCHAR(255) NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    message TEXT NOT NULL,
+    attachment_ref VARCHAR(512),
+    status disputestatus DEFAULT 'OPEN' NOT NULL,
+    resolution VARCHAR(32),
+    resolution_note TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    resolved_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_disputes_open_order ON disputes(user_id, order_id) WHERE status = 'OPEN';
+CREATE INDEX idx_disputes_user_id ON disputes(user_id);
+CREATE INDEX idx_disputes_status ON disputes(status, created_at);
+
+CREATE TABLE dispute_audit (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    dispute_id INT NOT NULL REFERENCES disputes(id),
+    actor VARCHAR(64) NOT NULL,
+    actor_ip VARCHAR(64),
+    action VARCHAR(32) NOT NULL,
+    details JSONB,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_dispute_audit_dispute_id ON dispute_audit(dispute_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_revoked_tokens_expires_at;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+DROP INDEX IF EXISTS idx_refresh_tokens_user_id;
+DROP TABLE IF EXISTS refresh_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS token_version;
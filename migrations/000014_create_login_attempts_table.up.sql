@@ -0,0 +1,8 @@
+CREATE TABLE login_attempts (
+    key VARCHAR(300) PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    locked_until TIMESTAMPTZ
+);
+
+CREATE INDEX idx_login_attempts_last_failure_at ON login_attempts(last_failure_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_totp_recovery_codes_user_code;
+DROP TABLE IF EXISTS totp_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,17 @@
+CREATE TABLE user_totp (
+    user_id INT PRIMARY KEY REFERENCES users(id),
+    secret VARCHAR(64) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    enabled_at TIMESTAMPTZ
+);
+
+CREATE TABLE totp_recovery_codes (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id INT NOT NULL REFERENCES users(id),
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_totp_recovery_codes_user_code ON totp_recovery_codes(user_id, code_hash);
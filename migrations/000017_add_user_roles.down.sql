@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_balance_adjustments_user_id;
+DROP TABLE IF EXISTS balance_adjustments;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+DROP TYPE IF EXISTS userrole;
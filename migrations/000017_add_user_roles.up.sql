@@ -0,0 +1,20 @@
+DO $$
+BEGIN
+    CREATE TYPE userrole AS ENUM ('user', 'support', 'admin');
+EXCEPTION
+    WHEN duplicate_object THEN NULL;
+END $$;
+
+ALTER TABLE users ADD COLUMN role userrole DEFAULT 'user' NOT NULL;
+
+CREATE TABLE balance_adjustments (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id INT NOT NULL REFERENCES users(id),
+    amount REAL NOT NULL,
+    reason TEXT NOT NULL,
+    actor VARCHAR(64) NOT NULL,
+    actor_ip VARCHAR(64),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_balance_adjustments_user_id ON balance_adjustments(user_id, created_at);
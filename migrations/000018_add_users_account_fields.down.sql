@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deleted_at,
+    DROP COLUMN IF EXISTS last_login_at,
+    DROP COLUMN IF EXISTS created_at;
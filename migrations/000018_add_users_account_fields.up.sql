@@ -0,0 +1,8 @@
+ALTER TABLE users
+    ADD COLUMN created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    ADD COLUMN last_login_at TIMESTAMPTZ,
+    ADD COLUMN deleted_at TIMESTAMPTZ;
+
+UPDATE users SET created_at = first_order.uploaded_at
+FROM (SELECT user_id, MIN(uploaded_at) AS uploaded_at FROM orders GROUP BY user_id) first_order
+WHERE first_order.user_id = users.id;
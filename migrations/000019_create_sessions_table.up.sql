@@ -0,0 +1,18 @@
+CREATE TABLE sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    user_agent VARCHAR(512),
+    ip VARCHAR(64),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    last_seen_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    ended_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+
+INSERT INTO sessions (id, user_id, created_at, last_seen_at)
+SELECT family_id, user_id, MIN(created_at), MAX(created_at)
+FROM refresh_tokens
+WHERE revoked_at IS NULL
+GROUP BY family_id, user_id
+ON CONFLICT (id) DO NOTHING;
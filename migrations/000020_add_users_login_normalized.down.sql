@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_login_normalized;
+ALTER TABLE users DROP COLUMN IF EXISTS login_normalized;
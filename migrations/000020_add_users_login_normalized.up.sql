@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN login_normalized VARCHAR(255);
+
+UPDATE users SET login_normalized = lower(btrim(login));
+
+-- Logins that differ only in case or surrounding spaces predate this
+-- column. The oldest account keeps the normalized login, the others can
+-- still sign in with their exact login.
+UPDATE users u SET login_normalized = NULL
+WHERE EXISTS (
+    SELECT 1 FROM users o WHERE o.login_normalized = u.login_normalized AND o.id < u.id
+);
+
+CREATE UNIQUE INDEX idx_users_login_normalized ON users(login_normalized);
@@ -0,0 +1,22 @@
+CREATE TABLE oidc_login_states (
+    state_hash VARCHAR(64) PRIMARY KEY,
+    provider VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    link_user_id INT REFERENCES users(id),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE user_identities (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id INT NOT NULL REFERENCES users(id),
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
@@ -0,0 +1,6 @@
+CREATE UNLOGGED TABLE rate_limits (
+    key VARCHAR(255) PRIMARY KEY,
+    tat TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_rate_limits_tat ON rate_limits(tat);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_balance_adjustments_order_id;
+ALTER TABLE balance_adjustments DROP COLUMN IF EXISTS order_id;
@@ -0,0 +1,5 @@
+-- Set when an adjustment moves an order's accrual from one user to another,
+-- so that the balance history can keep the accrual with whoever earned it.
+ALTER TABLE balance_adjustments ADD COLUMN order_id INT REFERENCES orders(id);
+
+CREATE INDEX idx_balance_adjustments_order_id ON balance_adjustments(order_id) WHERE order_id IS NOT NULL;